@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+	"github/wizzybenson/unblockchain/database"
+	"os"
+	"time"
+)
+
+const flagOffset = "offset"
+const flagLimit = "limit"
+const flagReindex = "reindex"
+
+func accountCmd() *cobra.Command {
+	var accountCmd = &cobra.Command{
+		Use:   "account",
+		Short: "Inspects accounts (history and other commands)",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	accountCmd.AddCommand(accountHistoryCmd())
+
+	return accountCmd
+}
+
+func accountHistoryCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "history <address>",
+		Short: "Lists the TXs an account sent or received, newest first",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if !common.IsHexAddress(args[0]) {
+				fmt.Fprintf(os.Stderr, "'%s' is not a valid account\n", args[0])
+				os.Exit(1)
+			}
+			account := common.HexToAddress(args[0])
+
+			offset, _ := cmd.Flags().GetUint(flagOffset)
+			limit, _ := cmd.Flags().GetUint(flagLimit)
+			reindex, _ := cmd.Flags().GetBool(flagReindex)
+			asset, _ := cmd.Flags().GetString(flagAsset)
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			if reindex {
+				if err := state.RebuildAccountIndex(); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			page, err := state.GetAccountTxs(account, asset, int(offset), int(limit))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if len(page.Txs) == 0 {
+				fmt.Printf("No TXs of %s past the %d newest, %d in total\n", account.Hex(), page.Offset, page.Total)
+				return
+			}
+
+			fmt.Printf("TXs of %s, %d to %d of %d\n", account.Hex(), page.Offset+1, page.Offset+len(page.Txs), page.Total)
+			fmt.Println("-----------------------")
+
+			for _, info := range page.Txs {
+				direction := "from " + info.Tx.From.Hex()
+				amount := fmt.Sprintf("+%d %s", info.Tx.Value, info.Tx.AssetSymbol())
+				if info.Tx.From == account {
+					direction = "to " + info.Tx.To.Hex()
+					amount = fmt.Sprintf("-%d TUB", info.Tx.Cost())
+					if !info.Tx.IsNative() {
+						amount = fmt.Sprintf("-%d %s -%d TUB", info.Tx.Value, info.Tx.Asset, info.Tx.Fee)
+					}
+					if info.Tx.IsMint() {
+						amount = fmt.Sprintf("-%d TUB", info.Tx.Fee)
+					}
+				}
+
+				fmt.Printf("%s block %d  %s %s", time.Unix(int64(info.Tx.Time), 0).UTC().Format(time.RFC3339), info.BlockNumber, amount, direction)
+				if info.Tx.Reason != "" {
+					fmt.Printf(" '%s'", info.Tx.Reason)
+				}
+				fmt.Printf("\n\ttx %s, %d confirmations\n", info.Hash.Hex(), info.Confirmations)
+			}
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+	cmd.Flags().Uint(flagOffset, 0, "Number of newest TXs to skip")
+	cmd.Flags().Uint(flagLimit, 20, "Max number of TXs to list")
+	cmd.Flags().Bool(flagReindex, false, "Rebuild the account index from the chain first")
+	cmd.Flags().String(flagAsset, "", "Only list the TXs of this asset, TUB for the native one")
+
+	return cmd
+}
@@ -19,6 +19,7 @@ func balancesCmd() *cobra.Command {
 		},
 	}
 	addDefaultRequiredCmds(balancesListCmd)
+	balancesListCmd.Flags().String(flagAsset, "", "Asset to list the balances of (default TUB)")
 	balancesCmd.AddCommand(balancesListCmd)
 	return balancesCmd
 }
@@ -34,11 +35,23 @@ var balancesListCmd = &cobra.Command{
 			os.Exit(1)
 		}
 		defer state.Close()
-		fmt.Printf("Account balances at %x\n", state.LatestBlockHash())
+
+		asset, _ := cmd.Flags().GetString(flagAsset)
+		balances, err := state.BalancesOf(asset)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if asset == "" {
+			asset = database.NativeAsset
+		}
+
+		fmt.Printf("Account %s balances at %x\n", asset, state.LatestBlockHash())
 		fmt.Println("-----------------------")
 		fmt.Println("")
 
-		for account, balance := range state.Balances {
+		for account, balance := range balances {
 			fmt.Println(fmt.Sprintf("%s: %d", account, balance))
 		}
 	},
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github/wizzybenson/unblockchain/database"
+	"github/wizzybenson/unblockchain/fs"
+	"os"
+)
+
+const flagFrom = "from"
+const flagTo = "to"
+const flagOut = "out"
+
+func chainCmd() *cobra.Command {
+	var chainCmd = &cobra.Command{
+		Use:   "chain",
+		Short: "Inspects the chain (info and other commands)",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	chainCmd.AddCommand(chainInfoCmd())
+	chainCmd.AddCommand(chainExportCmd())
+	chainCmd.AddCommand(chainImportCmd())
+	chainCmd.AddCommand(chainVerifyCmd())
+
+	return chainCmd
+}
+
+func chainInfoCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "info",
+		Short: "Shows the chain params, current reward and circulating supply",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			info, err := state.ChainInfo()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			maxSupply := "unlimited"
+			if info.MaxSupply > 0 {
+				maxSupply = fmt.Sprintf("%d TUB", info.MaxSupply)
+			}
+
+			halvingInterval := "never"
+			if info.HalvingInterval > 0 {
+				halvingInterval = fmt.Sprintf("every %d blocks", info.HalvingInterval)
+			}
+
+			fmt.Printf("Chain '%s'\n", info.ChainId)
+			fmt.Printf(" - latest block: %d '%s'\n", info.BlockNumber, info.BlockHash.Hex())
+			fmt.Printf(" - next block reward: %d TUB\n", info.BlockReward)
+			fmt.Printf(" - reward halving: %s\n", halvingInterval)
+			fmt.Printf(" - circulating supply: %d TUB\n", info.CirculatingSupply)
+			fmt.Printf(" - max supply: %s\n", maxSupply)
+			fmt.Printf(" - next block difficulty: %d\n", info.Difficulty)
+			fmt.Printf(" - target block time: %ds\n", info.TargetBlockTime)
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+
+	return cmd
+}
+
+func chainExportCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "export",
+		Short: "Exports canonical blocks to a file",
+		Long:  "Writes the canonical blocks between --from and --to into a portable file 'tub chain import' restores",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetUint64(flagFrom)
+			to, _ := cmd.Flags().GetUint64(flagTo)
+			out, _ := cmd.Flags().GetString(flagOut)
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			if !cmd.Flags().Changed(flagTo) {
+				to = state.LatestBlock().Header.Number
+			}
+
+			f, err := os.OpenFile(fs.ExpandPath(out), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			count, err := state.ExportBlocks(f, from, to)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(f.Name())
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Exported %d blocks of chain '%s' to %s\n", count, state.ChainId(), f.Name())
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+	cmd.Flags().Uint64(flagFrom, 0, "Number of the first block to export")
+	cmd.Flags().Uint64(flagTo, 0, "Number of the last block to export (default the latest block)")
+	cmd.Flags().String(flagOut, "", "Path of the export file to create")
+	cmd.MarkFlagRequired(flagOut)
+
+	return cmd
+}
+
+func chainImportCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Imports blocks exported with 'tub chain export'",
+		Long:  "Validates and adds every block of an export file, stopping at the first invalid one",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			f, err := os.Open(fs.ExpandPath(args[0]))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			count, err := state.ImportBlocks(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Imported %d blocks before failing. %s\n", count, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Imported %d blocks, the latest block is %d '%s'\n", count, state.LatestBlock().Header.Number, state.LatestBlockHash().Hex())
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+
+	return cmd
+}
+
+func chainVerifyCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verifies every block of the chain from the genesis",
+		Long:  "Replays the canonical chain without snapshots, re-checking parent links, heights, proof-of-work, signatures, nonces and balances",
+		Run: func(cmd *cobra.Command, args []string) {
+			result, err := database.VerifyChain(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Chain '%s'\n", result.ChainId)
+			fmt.Printf(" - blocks verified: %d of %d stored\n", result.Blocks, result.StoredBlocks)
+			fmt.Printf(" - latest valid block: %d '%s'\n", result.HeadNumber, result.Head.Hex())
+			fmt.Printf(" - TXs: %d transferring %d TUB\n", result.Txs, result.Transferred)
+			fmt.Printf(" - fees: %d TUB\n", result.Fees)
+			fmt.Printf(" - minted: %d TUB\n", result.Minted)
+			fmt.Printf(" - circulating supply: %d TUB over %d accounts\n", result.Supply, result.Accounts)
+
+			if result.Failure != nil {
+				fmt.Fprintf(os.Stderr, "Block %d '%s' is invalid. %s\n", result.Failure.Number, result.Failure.Hash.Hex(), result.Failure.Reason)
+				os.Exit(1)
+			}
+
+			fmt.Println("Chain is valid")
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+
+	return cmd
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github/wizzybenson/unblockchain/database"
+	"os"
+)
+
+func dbCmd() *cobra.Command {
+	var dbCmd = &cobra.Command{
+		Use:   "db",
+		Short: "Maintenance of the blocks database (repair and other commands)",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	dbCmd.AddCommand(dbRepairCmd())
+	dbCmd.AddCommand(dbSnapshotCmd())
+
+	return dbCmd
+}
+
+func dbRepairCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "repair",
+		Short: "Truncates the blocks database to its last valid block",
+		Long:  "Drops torn or corrupted blocks left behind by a crash and reports what was dropped",
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := database.RepairBlocksDb(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Repaired '%s' blocks database\n", report.Backend)
+			fmt.Printf(" - valid blocks: %d\n", report.ValidBlocks)
+			fmt.Printf(" - dropped records: %d\n", len(report.Dropped))
+
+			for _, dropped := range report.Dropped {
+				if dropped.Block == nil {
+					fmt.Printf("\t%d unreadable bytes at offset %d\n", dropped.Length, dropped.Offset)
+					continue
+				}
+
+				fmt.Printf("\tblock %d '%s' at offset %d\n", dropped.Block.Value.Header.Number, dropped.Block.Key.Hex(), dropped.Offset)
+			}
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+
+	return cmd
+}
+
+func dbSnapshotCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Persists a snapshot of the current state",
+		Long:  "Persists balances, nonces and the latest block so the next start only replays newer blocks",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			path, err := state.Snapshot()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("State at block %d '%s' snapshotted to %s\n", state.LatestBlock().Header.Number, state.LatestBlockHash().Hex(), path)
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+
+	return cmd
+}
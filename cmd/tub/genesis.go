@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+	"github/wizzybenson/unblockchain/database"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const flagChainId = "chain-id"
+const flagAlloc = "alloc"
+const flagBlockReward = "block-reward"
+const flagHalvingInterval = "halving-interval"
+const flagMaxSupply = "max-supply"
+const flagDifficulty = "difficulty"
+const flagTargetBlockTime = "target-block-time"
+const flagMaxBlockBytes = "max-block-bytes"
+const flagMaxBlockTxs = "max-block-txs"
+const flagMaxReasonLength = "max-reason-length"
+const flagAsset = "asset"
+const flagAssetAlloc = "asset-alloc"
+
+func genesisCmd() *cobra.Command {
+	var genesisCmd = &cobra.Command{
+		Use:   "genesis",
+		Short: "Manages the genesis of a chain (init and other commands)",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	genesisCmd.AddCommand(genesisInitCmd())
+
+	return genesisCmd
+}
+
+func genesisInitCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "init",
+		Short: "Initializes a data dir with the genesis of a new chain",
+		Long:  "Writes the genesis of a new chain with its initial balances and chain params into the data dir",
+		Run: func(cmd *cobra.Command, args []string) {
+			chainId, _ := cmd.Flags().GetString(flagChainId)
+			allocs, _ := cmd.Flags().GetStringArray(flagAlloc)
+			blockReward, _ := cmd.Flags().GetUint(flagBlockReward)
+			halvingInterval, _ := cmd.Flags().GetUint64(flagHalvingInterval)
+			maxSupply, _ := cmd.Flags().GetUint(flagMaxSupply)
+			difficulty, _ := cmd.Flags().GetUint64(flagDifficulty)
+			targetBlockTime, _ := cmd.Flags().GetUint64(flagTargetBlockTime)
+			maxBlockBytes, _ := cmd.Flags().GetUint64(flagMaxBlockBytes)
+			maxBlockTxs, _ := cmd.Flags().GetUint64(flagMaxBlockTxs)
+			maxReasonLength, _ := cmd.Flags().GetUint64(flagMaxReasonLength)
+			assetIssuers, _ := cmd.Flags().GetStringArray(flagAsset)
+			assetAllocs, _ := cmd.Flags().GetStringArray(flagAssetAlloc)
+
+			balances, err := parseAllocs(allocs)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			assets, err := parseAssets(assetIssuers, assetAllocs)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			dataDir := getDataDirFromCmd(cmd)
+			genesis := database.NewGenesis(chainId, balances, blockReward, halvingInterval, maxSupply, difficulty, targetBlockTime, database.BlockLimits{MaxBlockBytes: maxBlockBytes, MaxBlockTxs: maxBlockTxs, MaxReasonLength: maxReasonLength})
+			genesis.Assets = assets
+
+			if err := database.InitDataDirWithGenesis(dataDir, genesis); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Initialized chain '%s' in %s\n", genesis.ChainId, dataDir)
+			fmt.Printf(" - block reward: %d\n", genesis.InitialReward())
+			fmt.Printf(" - halving interval: %d blocks\n", genesis.HalvingInterval)
+			fmt.Printf(" - max supply: %d\n", genesis.MaxSupply)
+			fmt.Printf(" - difficulty: %d\n", genesis.InitialDifficulty())
+			fmt.Printf(" - target block time: %ds\n", genesis.BlockTime())
+			fmt.Printf(" - max block bytes: %d\n", genesis.Limits().MaxBlockBytes)
+			fmt.Printf(" - max block txs: %d\n", genesis.Limits().MaxBlockTxs)
+			fmt.Printf(" - max reason length: %d\n", genesis.Limits().MaxReasonLength)
+			for account, balance := range genesis.Balances {
+				fmt.Printf(" - %s: %d\n", account.Hex(), balance)
+			}
+			for _, asset := range genesis.Assets {
+				fmt.Printf(" - asset %s issued by %s\n", asset.Symbol, asset.Issuer.Hex())
+				for account, balance := range asset.Balances {
+					fmt.Printf("   - %s: %d %s\n", account.Hex(), balance, asset.Symbol)
+				}
+			}
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+	cmd.Flags().String(flagChainId, "", "Unique id of the new chain")
+	cmd.MarkFlagRequired(flagChainId)
+	cmd.Flags().StringArray(flagAlloc, nil, "Initial balance of an account as 'address=amount', repeatable")
+	cmd.Flags().Uint(flagBlockReward, 0, fmt.Sprintf("TUB minted to the miner of every block until the first halving (default %d)", database.DefaultBlockReward))
+	cmd.Flags().Uint64(flagHalvingInterval, 0, "Number of blocks after which the block reward halves, 0 to never halve")
+	cmd.Flags().Uint(flagMaxSupply, 0, "Max TUB ever in circulation, 0 for no cap")
+	cmd.Flags().Uint64(flagDifficulty, 0, fmt.Sprintf("Difficulty of the first block (default %d)", database.DefaultDifficulty))
+	cmd.Flags().Uint64(flagTargetBlockTime, 0, fmt.Sprintf("Seconds between blocks the difficulty is retargeted towards (default %d)", database.DefaultTargetBlockTime))
+	cmd.Flags().Uint64(flagMaxBlockBytes, 0, fmt.Sprintf("Max size of a block in bytes (default %d)", database.DefaultMaxBlockBytes))
+	cmd.Flags().Uint64(flagMaxBlockTxs, 0, fmt.Sprintf("Max number of TXs in a block (default %d)", database.DefaultMaxBlockTxs))
+	cmd.Flags().Uint64(flagMaxReasonLength, 0, fmt.Sprintf("Max length of a TX reason in bytes (default %d)", database.DefaultMaxReasonLength))
+	cmd.Flags().StringArray(flagAsset, nil, "Asset issued next to TUB as 'SYMBOL=issuer', repeatable")
+	cmd.Flags().StringArray(flagAssetAlloc, nil, "Initial balance of an account in an asset as 'SYMBOL:address=amount', repeatable")
+
+	return cmd
+}
+
+func parseAllocs(allocs []string) (map[common.Address]uint, error) {
+	balances := make(map[common.Address]uint)
+	for _, alloc := range allocs {
+		parts := strings.SplitN(alloc, "=", 2)
+		if len(parts) != 2 || !common.IsHexAddress(parts[0]) {
+			return nil, fmt.Errorf("invalid alloc '%s'. Use 'address=amount'", alloc)
+		}
+
+		amount, err := strconv.ParseUint(parts[1], 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alloc amount '%s'. %s", parts[1], err.Error())
+		}
+
+		balances[common.HexToAddress(parts[0])] += uint(amount)
+	}
+
+	return balances, nil
+}
+
+// parseAssets declares an asset per 'SYMBOL=issuer' of issuers, holding the
+// 'SYMBOL:address=amount' allocs.
+func parseAssets(issuers []string, allocs []string) ([]database.GenesisAsset, error) {
+	assets := make([]database.GenesisAsset, 0, len(issuers))
+	bySymbol := make(map[string]int)
+	for _, issuer := range issuers {
+		parts := strings.SplitN(issuer, "=", 2)
+		if len(parts) != 2 || !common.IsHexAddress(parts[1]) {
+			return nil, fmt.Errorf("invalid asset '%s'. Use 'SYMBOL=issuer'", issuer)
+		}
+
+		bySymbol[parts[0]] = len(assets)
+		assets = append(assets, database.GenesisAsset{Symbol: parts[0], Issuer: common.HexToAddress(parts[1]), Balances: make(map[common.Address]uint)})
+	}
+
+	for _, alloc := range allocs {
+		parts := strings.SplitN(alloc, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid asset alloc '%s'. Use 'SYMBOL:address=amount'", alloc)
+		}
+
+		i, ok := bySymbol[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("asset alloc '%s' is for an undeclared asset, add it with --%s", alloc, flagAsset)
+		}
+
+		balances, err := parseAllocs([]string{parts[1]})
+		if err != nil {
+			return nil, err
+		}
+
+		for account, balance := range balances {
+			assets[i].Balances[account] += balance
+		}
+	}
+
+	return assets, nil
+}
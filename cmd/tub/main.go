@@ -14,6 +14,8 @@ const flagKeystoreFile = "keystore"
 const flagBootstrapIp = "bootstrap-ip"
 const flagBootstrapAcc = "boostrap-account"
 const flagBootstrapPort = "bootstrap-port"
+const flagDbBackend = "db-backend"
+const flagGenesis = "genesis"
 
 func main() {
 
@@ -30,6 +32,11 @@ func main() {
 	tub.AddCommand(runCmd())
 	tub.AddCommand(migrateCmd())
 	tub.AddCommand(walletCmd())
+	tub.AddCommand(dbCmd())
+	tub.AddCommand(txCmd())
+	tub.AddCommand(genesisCmd())
+	tub.AddCommand(chainCmd())
+	tub.AddCommand(accountCmd())
 	if err := tub.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -1,71 +1,95 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github/wizzybenson/unblockchain/database"
-	"github/wizzybenson/unblockchain/node"
-	"github/wizzybenson/unblockchain/wallet"
-	"time"
+	"os"
 )
 
+const flagDryRun = "dry-run"
+const flagNoBackup = "no-backup"
+
 var migrateCmd = func() *cobra.Command {
 	var migrateCmd = &cobra.Command{
 		Use:   "migrate",
-		Short: "The Unblockchain Bar migration command",
+		Short: "Upgrades a data dir to the schema version of this build",
+		Long:  "Applies the pending data dir migrations in order, backing up the database first. With --db-backend, also moves the blocks to another backend. Chains written before blocks were hashed in their binary encoding can't be migrated and must be synced again from peers",
 		Run: func(cmd *cobra.Command, args []string) {
-			ip, _ := cmd.Flags().GetString(flagIP)
-			port, _ := cmd.Flags().GetUint64(flagPort)
-			miner, _ := cmd.Flags().GetString(flagMiner)
-
-			thanos := database.NewAccount(wallet.ThanosAccount)
-			maw := database.NewAccount(wallet.MawAccount)
-			proxima := database.NewAccount(wallet.ProximaAccount)
-
-			peer := node.NewPeerNode(
-				"127.0.0.1",
-				8086,
-				true,
-				thanos,
-				false,
-			)
-			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), peer)
-
-			n.AddPendingTX(database.NewTx(thanos, thanos, 3, ""), peer)
-			n.AddPendingTX(database.NewTx(thanos, thanos, 700, ""), peer)
-			n.AddPendingTX(database.NewTx(maw, thanos, 2000, ""), peer)
-			n.AddPendingTX(database.NewTx(thanos, thanos, 100, ""), peer)
-			n.AddPendingTX(database.NewTx(thanos, maw, 1, ""), peer)
-			n.AddPendingTX(database.NewTx(proxima, maw, 1000, ""), peer)
-			n.AddPendingTX(database.NewTx(thanos, maw, 50, ""), peer)
-
-			ctx, closeNode := context.WithTimeout(context.Background(), time.Minute*15)
-
-			go func() {
-				ticker := time.NewTicker(time.Second * 10)
-
-				for {
-					select {
-					case <-ticker.C:
-						if !n.LatestBlockHash().IsEmpty() {
-							closeNode()
-							return
-						}
-					}
+			dataDir := getDataDirFromCmd(cmd)
+			dryRun, _ := cmd.Flags().GetBool(flagDryRun)
+			noBackup, _ := cmd.Flags().GetBool(flagNoBackup)
+			backend, _ := cmd.Flags().GetString(flagDbBackend)
+			backend = database.NormalizeBackend(backend)
+
+			version, err := database.DataDirVersion(dataDir)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			pending, err := database.PendingMigrations(dataDir)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Data dir schema version: %d, this build uses %d\n", version, database.SchemaVersion)
+			for _, m := range pending {
+				fmt.Printf(" - %d: %s\n", m.Version, m.Description)
+			}
+
+			if dryRun {
+				fmt.Println("Dry run, migrating a temporary copy of the data dir")
+			}
+
+			report, err := database.MigrateDataDir(dataDir, dryRun, !noBackup)
+			if report.Backup != "" {
+				fmt.Printf("Database backed up to '%s'\n", report.Backup)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if len(report.Applied) == 0 {
+				fmt.Println("Data dir schema is up to date")
+			} else {
+				fmt.Printf("Migrated data dir from version %d to %d\n", report.From, report.To)
+			}
+
+			if backend == "" || backend == database.DetectBackend(dataDir) {
+				return
+			}
+
+			if dryRun && len(report.Applied) > 0 {
+				fmt.Printf("Skipping the move to the '%s' backend, the dry run left the schema at version %d\n", backend, report.From)
+				return
+			}
+
+			if !dryRun && !noBackup && report.Backup == "" {
+				backup, err := database.BackupDatabase(dataDir)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
 				}
-			}()
+				fmt.Printf("Database backed up to '%s'\n", backup)
+			}
 
-			err := n.Run(ctx)
+			count, err := database.ConvertBlockStore(dataDir, backend, dryRun)
 			if err != nil {
-				fmt.Println(err)
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
 			}
+
+			fmt.Printf("Moved %d blocks to the '%s' backend\n", count, backend)
 		},
 	}
+
 	addDefaultRequiredCmds(migrateCmd)
-	migrateCmd.Flags().String(flagIP, node.DefaultIp, "expose IP for communication with peers")
-	migrateCmd.Flags().Uint64(flagPort, node.DefaultHttpPort, "expose HTTP port for communication with peers")
-	migrateCmd.Flags().String(flagMiner, node.DefaultMiner, "Name of the node owner")
+	migrateCmd.Flags().Bool(flagDryRun, false, "Migrate a temporary copy of the data dir, leaving it untouched")
+	migrateCmd.Flags().Bool(flagNoBackup, false, "Skip backing up the database before migrating it")
+	migrateCmd.Flags().String(flagDbBackend, "", fmt.Sprintf("Move the blocks to another database backend, only '%s' is supported", database.BackendLevelDb))
 
 	return migrateCmd
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"github/wizzybenson/unblockchain/database"
+	"github/wizzybenson/unblockchain/fs"
 	"github/wizzybenson/unblockchain/node"
 	"os"
 )
@@ -20,6 +21,8 @@ func runCmd() *cobra.Command {
 			bootstrapIp, _ := cmd.Flags().GetString(flagBootstrapIp)
 			bootstrapPort, _ := cmd.Flags().GetUint64(flagBootstrapPort)
 			bootstrapAcc, _ := cmd.Flags().GetString(flagBootstrapAcc)
+			dbBackend, _ := cmd.Flags().GetString(flagDbBackend)
+			genesisPath, _ := cmd.Flags().GetString(flagGenesis)
 
 			fmt.Println("Starting TUB Node and it's HTTP API...")
 
@@ -30,7 +33,26 @@ func runCmd() *cobra.Command {
 				database.NewAccount(bootstrapAcc),
 				false,
 			)
-			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), bootstrap)
+			dataDir := getDataDirFromCmd(cmd)
+			if genesisPath != "" {
+				genesis, err := database.LoadGenesisFile(fs.ExpandPath(genesisPath))
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+
+				if err := database.InitDataDirWithGenesis(dataDir, genesis); err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+
+			if dbBackend == "" {
+				dbBackend = database.DetectBackend(dataDir)
+			}
+			dbBackend = database.NormalizeBackend(dbBackend)
+
+			n := node.New(dataDir, ip, port, database.NewAccount(miner), bootstrap, dbBackend)
 			err := n.Run(context.Background())
 			if err != nil {
 				fmt.Println(err)
@@ -45,5 +67,7 @@ func runCmd() *cobra.Command {
 	runCmd.Flags().String(flagBootstrapIp, node.DefaultBootstrapIp, "default bootstrap server to interconnect peers")
 	runCmd.Flags().Uint64(flagBootstrapPort, node.DefaultBootstrapPort, "default bootstrap server port to interconnect peers")
 	runCmd.Flags().String(flagBootstrapAcc, node.DefaultBootstrapAcc, "default bootstrap account to interconnect peers")
+	runCmd.Flags().String(flagGenesis, "", "genesis file to initialize the datadir with, must match the genesis of an initialized datadir")
+	runCmd.Flags().String(flagDbBackend, "", fmt.Sprintf("blocks storage backend, '%s' ('jsonl' is an alias of it) or '%s' (detected from the datadir by default)", database.BackendFile, database.BackendLevelDb))
 	return runCmd
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github/wizzybenson/unblockchain/database"
+	"os"
+)
+
+func txCmd() *cobra.Command {
+	var txCmd = &cobra.Command{
+		Use:   "tx",
+		Short: "Inspects transactions (proof and other commands)",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	txCmd.AddCommand(txProofCmd())
+
+	return txCmd
+}
+
+func txProofCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "proof <hash>",
+		Short: "Prints the Merkle inclusion proof of a transaction",
+		Long:  "Prints the header of the block including the transaction and the Merkle path from the transaction to the header tx root",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			txHash := database.Hash{}
+			if err := txHash.UnmarshalText([]byte(args[0])); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid tx hash '%s'. %s\n", args[0], err)
+				os.Exit(1)
+			}
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			proof, err := state.GetTxProof(txHash)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			proofJson, err := json.MarshalIndent(proof, "", "  ")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Println(string(proofJson))
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+
+	return cmd
+}
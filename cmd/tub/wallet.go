@@ -1,18 +1,33 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/console/prompt"
 	"github.com/spf13/cobra"
+	"github/wizzybenson/unblockchain/database"
 	"github/wizzybenson/unblockchain/wallet"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
-	"github.com/ethereum/go-ethereum/cmd/utils"
 	_ "github.com/ethereum/go-ethereum/console/prompt"
 	"io/ioutil"
 	"os"
 )
 
+const flagValue = "value"
+const flagFee = "fee"
+const flagNonce = "nonce"
+const flagReason = "reason"
+const flagLockHeight = "lock-height"
+const flagLockTime = "lock-time"
+const flagThreshold = "threshold"
+const flagMint = "mint"
+const flagSigner = "signer"
+const flagTxFile = "tx"
+const flagAccount = "account"
+const flagSender = "sender"
+
 func walletCmd() *cobra.Command {
 	var walletCmd = &cobra.Command{
 		Use: "wallet",
@@ -26,6 +41,9 @@ func walletCmd() *cobra.Command {
 
 	walletCmd.AddCommand(walletNewAccountCmd())
 	walletCmd.AddCommand(walletPrintPrivKeyCmd())
+	walletCmd.AddCommand(walletTxCreateCmd())
+	walletCmd.AddCommand(walletTxSignCmd())
+	walletCmd.AddCommand(walletTxCombineCmd())
 
 	return walletCmd
 }
@@ -85,22 +103,229 @@ func walletPrintPrivKeyCmd() *cobra.Command {
 	return cmd
 }
 
+func walletTxCreateCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "tx-create",
+		Short: "Writes an unsigned TX to a file, to be signed or cosigned by the signers of a multisig account",
+		Long:  "Writes an unsigned TX to a file. Give it a threshold and signers to register its sender as a multisig account",
+		Run: func(cmd *cobra.Command, args []string) {
+			rawFrom, _ := cmd.Flags().GetString(flagFrom)
+			rawTo, _ := cmd.Flags().GetString(flagTo)
+			value, _ := cmd.Flags().GetUint(flagValue)
+			fee, _ := cmd.Flags().GetUint(flagFee)
+			nonce, _ := cmd.Flags().GetUint(flagNonce)
+			reason, _ := cmd.Flags().GetString(flagReason)
+			chainId, _ := cmd.Flags().GetString(flagChainId)
+			asset, _ := cmd.Flags().GetString(flagAsset)
+			lockHeight, _ := cmd.Flags().GetUint64(flagLockHeight)
+			lockTime, _ := cmd.Flags().GetUint64(flagLockTime)
+			threshold, _ := cmd.Flags().GetUint(flagThreshold)
+			rawSigners, _ := cmd.Flags().GetStringArray(flagSigner)
+			mint, _ := cmd.Flags().GetBool(flagMint)
+			out, _ := cmd.Flags().GetString(flagOut)
+
+			if rawTo == "" {
+				rawTo = rawFrom
+			}
+
+			for _, raw := range append([]string{rawFrom, rawTo}, rawSigners...) {
+				if !common.IsHexAddress(raw) {
+					fmt.Fprintf(os.Stderr, "'%s' is not a valid account\n", raw)
+					os.Exit(1)
+				}
+			}
+
+			tx := database.NewTx(common.HexToAddress(rawTo), common.HexToAddress(rawFrom), value, fee, nonce, reason)
+			tx.ChainId = chainId
+			tx.Asset = asset
+			tx.LockHeight = lockHeight
+			tx.LockTime = lockTime
+			tx.Threshold = threshold
+			if mint {
+				tx.Kind = database.TxKindMint
+			}
+			for _, raw := range rawSigners {
+				tx.Signers = append(tx.Signers, common.HexToAddress(raw))
+			}
+
+			if err := writeTxFile(out, database.NewSignedTx(tx, nil)); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			txHash, _ := tx.Hash()
+			fmt.Printf("Unsigned TX %s written to %s\n", txHash.Hex(), out)
+		},
+	}
+
+	cmd.Flags().String(flagFrom, "", "Sender of the TX, e.g. the multisig account")
+	cmd.MarkFlagRequired(flagFrom)
+	cmd.Flags().String(flagTo, "", "Recipient of the TX (default the sender)")
+	cmd.Flags().Uint(flagValue, 0, "Value transferred")
+	cmd.Flags().Uint(flagFee, 0, "TUB fee paid to the miner")
+	cmd.Flags().Uint(flagNonce, 0, "Next nonce of the sender")
+	cmd.MarkFlagRequired(flagNonce)
+	cmd.Flags().String(flagReason, "", "Reason of the TX")
+	cmd.Flags().String(flagChainId, "", "Chain the TX is bound to")
+	cmd.MarkFlagRequired(flagChainId)
+	cmd.Flags().String(flagAsset, "", "Asset transferred (default TUB)")
+	cmd.Flags().Bool(flagMint, false, "Mint Value new units of the asset, the sender must be its issuer")
+	cmd.Flags().Uint64(flagLockHeight, 0, "Block from which the TX can be mined")
+	cmd.Flags().Uint64(flagLockTime, 0, "Unix time from which the TX can be mined")
+	cmd.Flags().Uint(flagThreshold, 0, "Cosignatures the sender needs once registered as a multisig account")
+	cmd.Flags().StringArray(flagSigner, nil, "Signer of the multisig account registered by the TX, repeatable")
+	cmd.Flags().String(flagOut, "", "File to write the TX to")
+	cmd.MarkFlagRequired(flagOut)
+
+	return cmd
+}
+
+func walletTxSignCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "tx-sign",
+		Short: "Cosigns a TX file with a keystore account, or signs it as its sender",
+		Run: func(cmd *cobra.Command, args []string) {
+			rawAccount, _ := cmd.Flags().GetString(flagAccount)
+			txFile, _ := cmd.Flags().GetString(flagTxFile)
+			sender, _ := cmd.Flags().GetBool(flagSender)
+
+			if !common.IsHexAddress(rawAccount) {
+				fmt.Fprintf(os.Stderr, "'%s' is not a valid account\n", rawAccount)
+				os.Exit(1)
+			}
+			account := common.HexToAddress(rawAccount)
+
+			tx, err := readTxFile(txFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			password := getPassPhrase(fmt.Sprintf("Please enter the password of %s:", account.Hex()), false)
+			keystoreDir := wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd))
+
+			if sender {
+				if account != tx.From {
+					fmt.Fprintf(os.Stderr, "%s is not the sender of the TX, %s is\n", account.Hex(), tx.From.Hex())
+					os.Exit(1)
+				}
+
+				tx, err = wallet.SignWithKeystoreAccount(tx.Tx, tx.ChainId, account, password, keystoreDir)
+			} else {
+				tx, err = wallet.CosignWithKeystoreAccount(tx, account, password, keystoreDir)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if err := writeTxFile(txFile, tx); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if sender {
+				fmt.Printf("TX signed by its sender %s\n", account.Hex())
+				return
+			}
+
+			fmt.Printf("TX cosigned by %s, %d cosignatures\n", account.Hex(), len(tx.Sigs))
+		},
+	}
+
+	addDefaultRequiredCmds(cmd)
+	cmd.Flags().String(flagAccount, "", "Keystore account signing the TX")
+	cmd.MarkFlagRequired(flagAccount)
+	cmd.Flags().String(flagTxFile, "", "TX file written by tx-create, signed in place")
+	cmd.MarkFlagRequired(flagTxFile)
+	cmd.Flags().Bool(flagSender, false, "Sign as the single key sender instead of cosigning")
+
+	return cmd
+}
+
+func walletTxCombineCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "tx-combine <tx file> <tx file>...",
+		Short: "Combines the cosignatures of copies of a TX, ready to be posted to a node /tx/submit",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out, _ := cmd.Flags().GetString(flagOut)
+
+			txs := make([]database.SignedTx, len(args))
+			for i, file := range args {
+				tx, err := readTxFile(file)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				txs[i] = tx
+			}
+
+			combined, err := wallet.CombineCosignedTxs(txs)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if err := writeTxFile(out, combined); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("TX with %d cosignatures written to %s\n", len(combined.Sigs), out)
+		},
+	}
+
+	cmd.Flags().String(flagOut, "", "File to write the combined TX to")
+	cmd.MarkFlagRequired(flagOut)
+
+	return cmd
+}
+
+func readTxFile(path string) (database.SignedTx, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return database.SignedTx{}, err
+	}
+
+	var tx database.SignedTx
+	if err := json.Unmarshal(content, &tx); err != nil {
+		return database.SignedTx{}, fmt.Errorf("invalid TX file '%s'. %s", path, err.Error())
+	}
+
+	return tx, nil
+}
+
+func writeTxFile(path string, tx database.SignedTx) error {
+	txJson, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, txJson, 0600)
+}
+
 func getPassPhrase(promptText string, confirmation bool) string {
 	fmt.Println(promptText)
 	password, err := prompt.Stdin.PromptPassword("Password: ")
 	if err != nil {
-		utils.Fatalf("Failed to read password: %v", err)
+		fatalf("Failed to read password: %v", err)
 	}
 
 	if confirmation {
 		confirm, err := prompt.Stdin.PromptPassword("Repeat password: ")
 		if err != nil {
-			utils.Fatalf("Failed to read password confirmation: %v", err)
+			fatalf("Failed to read password confirmation: %v", err)
 		}
 		if password != confirm {
-			utils.Fatalf("Passwords do not match")
+			fatalf("Passwords do not match")
 		}
 	}
 
 	return password
 }
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Fatal: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,391 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// accountIndexMagic prefixes account.idx so indexes of an older layout get
+// rebuilt. Version 2 records hold the tx hash.
+var accountIndexMagic = []byte("TUBACC02")
+
+// accountIndexRecordSize is the size of one account.idx record: 1 byte kind,
+// 20 bytes account, 32 bytes block hash, 8 bytes block number, 4 bytes index
+// of the tx in the block and 32 bytes tx hash.
+const accountIndexRecordSize = 1 + common.AddressLength + 32 + 8 + 4 + 32
+
+// account.idx is a journal of the canonical blocks being indexed, one
+// recordTx per account a tx touches followed by a recordBlock, and of the
+// blocks dropped from the canonical chain by a reorg.
+const (
+	recordTx = iota
+	recordBlock
+	recordDropBlock
+)
+
+type accountTx struct {
+	blockHash Hash
+	number    uint64
+	index     uint32
+}
+
+// accountIndex maps accounts to the canonical txs they sent or received, in
+// chain order, and the hashes of those txs to their block.
+type accountIndex struct {
+	mu        sync.RWMutex
+	file      *os.File
+	byAccount map[common.Address][]accountTx
+	byTx      map[Hash]accountTx
+	indexed   map[Hash]bool
+}
+
+// AccountTxs is a page of the txs of an account, newest first.
+type AccountTxs struct {
+	Account common.Address `json:"account"`
+	Asset   string         `json:"asset,omitempty"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	Txs     []TxInfo       `json:"txs"`
+}
+
+func openAccountIndex(path string) (*accountIndex, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &accountIndex{file: f}
+	idx.clear()
+
+	if err := idx.load(); err != nil {
+		fmt.Printf("Account index is invalid, rebuilding it. %s\n", err)
+
+		if err := idx.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+func (idx *accountIndex) clear() {
+	idx.byAccount = make(map[common.Address][]accountTx)
+	idx.byTx = make(map[Hash]accountTx)
+	idx.indexed = make(map[Hash]bool)
+}
+
+func (idx *accountIndex) load() error {
+	content, err := ioutil.ReadAll(idx.file)
+	if err != nil {
+		return err
+	}
+
+	if len(content) == 0 {
+		_, err := idx.file.Write(accountIndexMagic)
+		return err
+	}
+
+	if !bytes.HasPrefix(content, accountIndexMagic) {
+		return fmt.Errorf("unknown index layout")
+	}
+	content = content[len(accountIndexMagic):]
+
+	if len(content)%accountIndexRecordSize != 0 {
+		return fmt.Errorf("index size %d is not a multiple of %d", len(content), accountIndexRecordSize)
+	}
+
+	for i := 0; i < len(content); i += accountIndexRecordSize {
+		if err := idx.apply(content[i : i+accountIndexRecordSize]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (idx *accountIndex) reset() error {
+	if err := idx.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := idx.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := idx.file.Write(accountIndexMagic); err != nil {
+		return err
+	}
+
+	idx.clear()
+
+	return nil
+}
+
+func encodeAccountIndexRecord(kind byte, account common.Address, blockHash Hash, number uint64, index uint32, txHash Hash) []byte {
+	record := make([]byte, accountIndexRecordSize)
+	record[0] = kind
+	copy(record[1:21], account[:])
+	copy(record[21:53], blockHash[:])
+	binary.BigEndian.PutUint64(record[53:61], number)
+	binary.BigEndian.PutUint32(record[61:65], index)
+	copy(record[65:97], txHash[:])
+
+	return record
+}
+
+func (idx *accountIndex) apply(record []byte) error {
+	var account common.Address
+	var tx accountTx
+	var txHash Hash
+	copy(account[:], record[1:21])
+	copy(tx.blockHash[:], record[21:53])
+	tx.number = binary.BigEndian.Uint64(record[53:61])
+	tx.index = binary.BigEndian.Uint32(record[61:65])
+	copy(txHash[:], record[65:97])
+
+	switch record[0] {
+	case recordTx:
+		idx.byAccount[account] = append(idx.byAccount[account], tx)
+		idx.byTx[txHash] = tx
+	case recordBlock:
+		idx.indexed[tx.blockHash] = true
+	case recordDropBlock:
+		delete(idx.indexed, tx.blockHash)
+		for account, txs := range idx.byAccount {
+			kept := txs[:0]
+			for _, t := range txs {
+				if t.blockHash != tx.blockHash {
+					kept = append(kept, t)
+				}
+			}
+			idx.byAccount[account] = kept
+		}
+		for txHash, t := range idx.byTx {
+			if t.blockHash == tx.blockHash {
+				delete(idx.byTx, txHash)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown record kind %d", record[0])
+	}
+
+	return nil
+}
+
+func (idx *accountIndex) write(records [][]byte) error {
+	content := bytes.Join(records, nil)
+	if _, err := idx.file.Write(content); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := idx.apply(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (idx *accountIndex) isIndexed(hash Hash) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.indexed[hash]
+}
+
+// add indexes the txs of the canonical block b under their sender and
+// recipient.
+func (idx *accountIndex) add(hash Hash, b Block) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.indexed[hash] {
+		return nil
+	}
+
+	records := make([][]byte, 0, 2*len(b.Txs)+1)
+	for i, tx := range b.Txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return err
+		}
+
+		records = append(records, encodeAccountIndexRecord(recordTx, tx.From, hash, b.Header.Number, uint32(i), txHash))
+		if tx.To != tx.From {
+			records = append(records, encodeAccountIndexRecord(recordTx, tx.To, hash, b.Header.Number, uint32(i), txHash))
+		}
+	}
+	records = append(records, encodeAccountIndexRecord(recordBlock, common.Address{}, hash, b.Header.Number, 0, Hash{}))
+
+	return idx.write(records)
+}
+
+// drop forgets the txs of a block that is no longer canonical.
+func (idx *accountIndex) drop(hash Hash) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.indexed[hash] {
+		return nil
+	}
+
+	return idx.write([][]byte{encodeAccountIndexRecord(recordDropBlock, common.Address{}, hash, 0, 0, Hash{})})
+}
+
+func (idx *accountIndex) txsOf(account common.Address) []accountTx {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return append([]accountTx(nil), idx.byAccount[account]...)
+}
+
+// txLocation returns the canonical block and index of the tx with hash
+// txHash.
+func (idx *accountIndex) txLocation(txHash Hash) (accountTx, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	tx, ok := idx.byTx[txHash]
+
+	return tx, ok
+}
+
+func (idx *accountIndex) close() error {
+	return idx.file.Close()
+}
+
+// syncAccountIndex indexes the canonical blocks the index is missing, walking
+// back from the head to the newest block already indexed.
+func (s *State) syncAccountIndex() error {
+	if !s.hasGenesisBlock {
+		return nil
+	}
+
+	missing := make([]BlockFs, 0)
+	for number := int64(s.latestBlock.Header.Number); number >= 0; number-- {
+		b, err := s.store.GetByHeight(uint64(number))
+		if errors.Is(err, ErrBlockNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			return err
+		}
+
+		if s.accountIndex.isIndexed(hash) {
+			break
+		}
+
+		missing = append(missing, BlockFs{hash, b})
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := s.accountIndex.add(missing[i].Key, missing[i].Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RebuildAccountIndex indexes the txs of every account again from the
+// canonical chain.
+func (s *State) RebuildAccountIndex() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accountIndex.mu.Lock()
+	err := s.accountIndex.reset()
+	s.accountIndex.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.syncAccountIndex()
+}
+
+// GetAccountTxs returns up to limit txs sent or received by account, newest
+// first, skipping the offset newest ones. A non empty asset only keeps the txs
+// of that asset, TUB for the native one.
+func (s *State) GetAccountTxs(account common.Address, asset string, offset int, limit int) (AccountTxs, error) {
+	if offset < 0 || limit < 0 {
+		return AccountTxs{}, fmt.Errorf("offset and limit must not be negative")
+	}
+
+	if s.accountIndex == nil {
+		return AccountTxs{}, fmt.Errorf("the account index is not open")
+	}
+
+	txs := s.accountIndex.txsOf(account)
+	page := AccountTxs{account, asset, len(txs), offset, make([]TxInfo, 0)}
+
+	if asset == "" {
+		for i := len(txs) - 1 - offset; i >= 0 && len(page.Txs) < limit; i-- {
+			info, err := s.accountTxInfo(txs[i])
+			if err != nil {
+				return AccountTxs{}, err
+			}
+
+			page.Txs = append(page.Txs, info)
+		}
+
+		return page, nil
+	}
+
+	// Txs are not indexed by asset, so all of them are read to be filtered.
+	page.Total = 0
+	for i := len(txs) - 1; i >= 0; i-- {
+		info, err := s.accountTxInfo(txs[i])
+		if err != nil {
+			return AccountTxs{}, err
+		}
+
+		if info.Tx.AssetSymbol() != asset {
+			continue
+		}
+
+		if page.Total >= offset && len(page.Txs) < limit {
+			page.Txs = append(page.Txs, info)
+		}
+		page.Total++
+	}
+
+	return page, nil
+}
+
+func (s *State) accountTxInfo(accTx accountTx) (TxInfo, error) {
+	b, err := s.GetBlockByHash(accTx.blockHash)
+	if err != nil {
+		return TxInfo{}, err
+	}
+
+	if int(accTx.index) >= len(b.Txs) {
+		return TxInfo{}, fmt.Errorf("block '%s' has no tx at index %d, rebuild the account index", accTx.blockHash.Hex(), accTx.index)
+	}
+
+	info, err := s.blockInfo(b)
+	if err != nil {
+		return TxInfo{}, err
+	}
+
+	tx := b.Txs[accTx.index]
+	txHash, err := tx.Hash()
+	if err != nil {
+		return TxInfo{}, err
+	}
+
+	return TxInfo{txHash, tx, info.Hash, b.Header.Number, int(accTx.index), info.Confirmations}, nil
+}
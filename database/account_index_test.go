@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestGetAccountTxs(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	store, err := openBlockStore(dataDir, BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// createTestTxs sends from 0x1 to 0x2.
+	txs := createTestTxs(5)
+	blocks := appendTestChain(t, store, [][]SignedTx{txs[:2], nil, txs[2:]})
+	head := blocks[len(blocks)-1]
+
+	idx, err := openAccountIndex(getAccountIndexFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &State{store: store, latestBlock: head.Value, latestBlockHash: head.Key, hasGenesisBlock: true, accountIndex: idx}
+	if err := s.syncAccountIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := s.GetAccountTxs(NewAccount("0x2"), "", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 5 || len(page.Txs) != 3 {
+		t.Fatalf("page should hold 3 of the 5 TXs, got %d of %d", len(page.Txs), page.Total)
+	}
+
+	if page.Txs[0].Tx.Nonce != 4 || page.Txs[2].Tx.Nonce != 2 || page.Txs[2].BlockNumber != 0 || page.Txs[2].Confirmations != 3 {
+		t.Fatalf("page should list the TXs with nonce 4 to 2 newest first, got %+v", page.Txs)
+	}
+
+	if err := idx.drop(head.Key); err != nil {
+		t.Fatal(err)
+	}
+	idx.close()
+
+	idx, err = openAccountIndex(getAccountIndexFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.close()
+	s.accountIndex = idx
+
+	page, err = s.GetAccountTxs(NewAccount("0x1"), "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 2 {
+		t.Fatalf("TXs of the dropped block should be gone after reopening the index, got %d TXs", page.Total)
+	}
+
+	droppedTxHash, err := txs[4].Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetTxInfo(droppedTxHash); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("TX of the dropped block should not be found, got %v", err)
+	}
+
+	if err := s.RebuildAccountIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err = s.GetAccountTxs(NewAccount("0x1"), "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 5 {
+		t.Fatalf("rebuilt index should hold every TX of the chain, got %d", page.Total)
+	}
+
+	info, err := s.GetTxInfo(droppedTxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.BlockHash != head.Key || info.Index != 2 {
+		t.Fatalf("TX should be the 3rd of the head block again, got %+v", info)
+	}
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"regexp"
+	"sort"
+)
+
+// NativeAsset is the asset of txs without an asset. Fees and block rewards
+// are always paid in it.
+const NativeAsset = "TUB"
+
+var assetSymbolPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,11}$`)
+
+// GenesisAsset declares a token issued on the chain next to TUB. Only its
+// Issuer can mint new units, Balances are the units held from the genesis.
+type GenesisAsset struct {
+	Symbol   string                  `json:"symbol"`
+	Name     string                  `json:"name,omitempty"`
+	Issuer   common.Address          `json:"issuer"`
+	Balances map[common.Address]uint `json:"balances,omitempty"`
+}
+
+// AssetBalance is the balance of an account in an asset other than TUB.
+type AssetBalance struct {
+	Asset   string         `json:"asset"`
+	Account common.Address `json:"account"`
+	Balance uint           `json:"balance"`
+}
+
+func validateAssetSymbol(symbol string) error {
+	if symbol == NativeAsset {
+		return fmt.Errorf("asset '%s' is the native asset, leave the asset empty instead", symbol)
+	}
+
+	if !assetSymbolPattern.MatchString(symbol) {
+		return fmt.Errorf("asset symbol '%s' must be 2 to 12 upper case letters or digits, starting with a letter", symbol)
+	}
+
+	return nil
+}
+
+func (g Genesis) validateAssets() error {
+	symbols := make(map[string]bool)
+	for _, asset := range g.Assets {
+		if err := validateAssetSymbol(asset.Symbol); err != nil {
+			return err
+		}
+
+		if symbols[asset.Symbol] {
+			return fmt.Errorf("asset '%s' is declared twice", asset.Symbol)
+		}
+		symbols[asset.Symbol] = true
+
+		if asset.Issuer == (common.Address{}) {
+			return fmt.Errorf("asset '%s' has no issuer", asset.Symbol)
+		}
+	}
+
+	return nil
+}
+
+// Asset returns the genesis declaration of the asset symbol.
+func (g Genesis) Asset(symbol string) (GenesisAsset, bool) {
+	for _, asset := range g.Assets {
+		if asset.Symbol == symbol {
+			return asset, true
+		}
+	}
+
+	return GenesisAsset{}, false
+}
+
+func (g Genesis) copyAssetBalances() map[string]map[common.Address]uint {
+	balances := make(map[string]map[common.Address]uint)
+	for _, asset := range g.Assets {
+		balances[asset.Symbol] = make(map[common.Address]uint)
+		for acc, balance := range asset.Balances {
+			balances[asset.Symbol][acc] = balance
+		}
+	}
+
+	return balances
+}
+
+func copyAssetBalances(assets map[string]map[common.Address]uint) map[string]map[common.Address]uint {
+	c := make(map[string]map[common.Address]uint)
+	for symbol, balances := range assets {
+		c[symbol] = make(map[common.Address]uint)
+		for acc, balance := range balances {
+			c[symbol][acc] = balance
+		}
+	}
+
+	return c
+}
+
+// Assets returns the assets declared by the genesis, TUB excluded.
+func (s *State) Assets() []GenesisAsset {
+	return s.genesis.Assets
+}
+
+// Balance returns the balance of account in asset, TUB for an empty asset.
+func (s *State) Balance(asset string, account common.Address) uint {
+	if asset == "" || asset == NativeAsset {
+		return s.Balances[account]
+	}
+
+	return s.AssetBalances[asset][account]
+}
+
+// BalancesOf returns the balances of every account holding asset, TUB for an
+// empty asset.
+func (s *State) BalancesOf(asset string) (map[common.Address]uint, error) {
+	if asset == "" || asset == NativeAsset {
+		return s.Balances, nil
+	}
+
+	if _, ok := s.genesis.Asset(asset); !ok {
+		return nil, fmt.Errorf("asset '%s' is not declared by the genesis", asset)
+	}
+
+	return s.AssetBalances[asset], nil
+}
+
+func (s *State) setAssetBalance(asset string, account common.Address, balance uint) {
+	balances, ok := s.AssetBalances[asset]
+	if !ok {
+		balances = make(map[common.Address]uint)
+		s.AssetBalances[asset] = balances
+	}
+
+	prev, existed := balances[account]
+	s.record(assetBalanceChange{asset, account, prev, existed})
+
+	balances[account] = balance
+}
+
+// applyAssetTx moves, or mints, the Value of an asset tx. The TUB fee is
+// charged by the caller.
+func applyAssetTx(tx SignedTx, s *State) error {
+	asset, ok := s.genesis.Asset(tx.Asset)
+	if !ok {
+		return fmt.Errorf("wrong TX. Asset '%s' is not declared by the genesis", tx.Asset)
+	}
+
+	if tx.IsMint() {
+		if tx.From != asset.Issuer {
+			return fmt.Errorf("wrong TX. Only issuer '%s' can mint '%s'", asset.Issuer.String(), asset.Symbol)
+		}
+
+		if s.Balance(tx.Asset, tx.To)+tx.Value < tx.Value {
+			return fmt.Errorf("wrong TX. Minting %d %s overflows the balance of '%s'", tx.Value, tx.Asset, tx.To.String())
+		}
+
+		s.setAssetBalance(tx.Asset, tx.To, s.Balance(tx.Asset, tx.To)+tx.Value)
+
+		return nil
+	}
+
+	if s.Balance(tx.Asset, tx.From) < tx.Value {
+		return fmt.Errorf("wrong TX. Sender '%s' balance is %d %s. Tx value is %d %s", tx.From.String(), s.Balance(tx.Asset, tx.From), tx.Asset, tx.Value, tx.Asset)
+	}
+
+	if tx.From != tx.To && s.Balance(tx.Asset, tx.To)+tx.Value < tx.Value {
+		return fmt.Errorf("wrong TX. Transferring %d %s overflows the balance of '%s'", tx.Value, tx.Asset, tx.To.String())
+	}
+
+	s.setAssetBalance(tx.Asset, tx.From, s.Balance(tx.Asset, tx.From)-tx.Value)
+	s.setAssetBalance(tx.Asset, tx.To, s.Balance(tx.Asset, tx.To)+tx.Value)
+
+	return nil
+}
+
+// assetBalances returns the non zero asset balances of s sorted by asset
+// and account.
+func (s *State) assetBalances() []AssetBalance {
+	balances := make([]AssetBalance, 0)
+	for asset, accounts := range s.AssetBalances {
+		for acc, balance := range accounts {
+			if balance > 0 {
+				balances = append(balances, AssetBalance{asset, acc, balance})
+			}
+		}
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].Asset != balances[j].Asset {
+			return balances[i].Asset < balances[j].Asset
+		}
+
+		return bytes.Compare(balances[i].Account[:], balances[j].Account[:]) < 0
+	})
+
+	return balances
+}
+
+func (a AssetBalance) leaf() Hash {
+	content := make([]byte, 0, 2+len(a.Asset)+common.AddressLength+8)
+	content = append(content, merkleLeafPrefix, byte(len(a.Asset)))
+	content = append(content, a.Asset...)
+	content = append(content, a.Account[:]...)
+
+	balance := make([]byte, 8)
+	binary.BigEndian.PutUint64(balance, uint64(a.Balance))
+
+	return sha256.Sum256(append(content, balance...))
+}
+
+// assetsRoot returns the Merkle root of the asset balances of s, or false
+// when no account holds any asset.
+func (s *State) assetsRoot() (Hash, bool) {
+	balances := s.assetBalances()
+	if len(balances) == 0 {
+		return Hash{}, false
+	}
+
+	nodes := make([]Hash, len(balances))
+	for i, balance := range balances {
+		nodes[i] = balance.leaf()
+	}
+
+	for len(nodes) > 1 {
+		nodes = merkleLevel(nodes)
+	}
+
+	return nodes[0], true
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAssetTxs(t *testing.T) {
+	issuerKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, holder := crypto.PubkeyToAddress(issuerKey.PublicKey), crypto.PubkeyToAddress(holderKey.PublicKey)
+
+	s, dataDir := newGenesisTestChainState(t, Genesis{
+		GenesisTime: time.Now().UTC().Format(time.RFC3339Nano),
+		ChainId:     "bar",
+		Balances:    map[common.Address]uint{issuer: 100},
+		Difficulty:  1,
+		Assets:      []GenesisAsset{{Symbol: "GOLD", Issuer: issuer, Balances: map[common.Address]uint{issuer: 50}}},
+	})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	mineTestBlock(t, s)
+
+	signTx := func(key *ecdsa.PrivateKey, asset string, value uint, kind TxKind) SignedTx {
+		from := crypto.PubkeyToAddress(key.PublicKey)
+		to := holder
+		if from == holder {
+			to = issuer
+		}
+
+		tx := NewTx(to, from, value, 1, s.GetNextAccountNonce(from), "")
+		tx.Kind = kind
+		tx.ChainId = "bar"
+		tx.Asset = asset
+		tx.Time = s.LatestBlock().Header.Time
+
+		return signTestTx(t, tx, key)
+	}
+
+	transfer := signTx(issuerKey, "GOLD", 20, TxKindTransfer)
+	mineTestTxs(t, s, []SignedTx{transfer})
+	mint := signTx(issuerKey, "GOLD", 5, TxKindMint)
+	mineTestTxs(t, s, []SignedTx{mint})
+
+	if s.Balance("GOLD", issuer) != 30 || s.Balance("GOLD", holder) != 25 {
+		t.Fatalf("issuer should hold 30 GOLD and holder 25, got %d and %d", s.Balance("GOLD", issuer), s.Balance("GOLD", holder))
+	}
+
+	if s.Balance(NativeAsset, issuer) != 98 || s.Balance(NativeAsset, holder) != 0 {
+		t.Fatalf("asset txs should only cost their TUB fee, got %d and %d TUB", s.Balance(NativeAsset, issuer), s.Balance(NativeAsset, holder))
+	}
+
+	proof, err := s.GetAccountProof(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proof.Verify(); err != nil || proof.AssetsRoot == nil {
+		t.Fatalf("account proof should verify against a state root committing to assets. %v", err)
+	}
+
+	for asset, total := range map[string]int{"": 2, "GOLD": 2, NativeAsset: 0} {
+		page, err := s.GetAccountTxs(holder, asset, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != total || len(page.Txs) != total {
+			t.Fatalf("holder should have %d '%s' txs, got %d", total, asset, page.Total)
+		}
+	}
+
+	dryRun := s.DryRunTxs([]SignedTx{
+		signTx(holderKey, "GOLD", 1, TxKindMint),
+		signTx(issuerKey, "SILVER", 1, TxKindTransfer),
+		signTx(issuerKey, NativeAsset, 1, TxKindTransfer),
+		signTx(issuerKey, "", 1, TxKindMint),
+		signTx(issuerKey, "GOLD", 1, TxKindMint+1),
+	}, NewAccount("0x9"), uint64(time.Now().Unix()))
+	if len(dryRun.Applied) != 0 || len(dryRun.Rejected) != 5 {
+		t.Fatalf("mints by another account than the issuer or of TUB, txs of unknown kinds and of undeclared assets should be rejected, got %+v", dryRun)
+	}
+
+	// Only the signed kind makes a mint, not the reason.
+	reasonMint := NewTx(holder, issuer, 31, 1, s.GetNextAccountNonce(issuer), "mint")
+	reasonMint.ChainId = "bar"
+	reasonMint.Asset = "GOLD"
+	reasonMint.Time = s.LatestBlock().Header.Time
+	if dryRun := s.DryRunTxs([]SignedTx{signTestTx(t, reasonMint, issuerKey)}, NewAccount("0x9"), uint64(time.Now().Unix())); len(dryRun.Rejected) != 1 {
+		t.Fatal("a transfer with a 'mint' reason should spend the 30 GOLD of the issuer, not mint 31")
+	}
+
+	holderTx := signTx(holderKey, "GOLD", 26, TxKindTransfer)
+	holderTx.Fee = 0
+	holderTx = signTestTx(t, holderTx.Tx, holderKey)
+	if dryRun := s.DryRunTxs([]SignedTx{holderTx}, NewAccount("0x9"), uint64(time.Now().Unix())); len(dryRun.Rejected) != 1 {
+		t.Fatal("a tx spending more of an asset than the sender holds should be rejected")
+	}
+
+	mineTestTxs(t, s, []SignedTx{signTx(issuerKey, "GOLD", ^uint(0)-s.Balance("GOLD", holder), TxKindMint)})
+	if dryRun := s.DryRunTxs([]SignedTx{signTx(issuerKey, "GOLD", 1, TxKindTransfer)}, NewAccount("0x9"), uint64(time.Now().Unix())); len(dryRun.Rejected) != 1 {
+		t.Fatal("a tx overflowing the asset balance of the recipient should be rejected")
+	}
+}
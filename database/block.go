@@ -4,13 +4,10 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 )
 
-const BlockReward = 100
-
 type Hash [32]byte
 
 func (h Hash) MarshalText() ([]byte, error) {
@@ -18,6 +15,10 @@ func (h Hash) MarshalText() ([]byte, error) {
 }
 
 func (h *Hash) UnmarshalText(data []byte) error {
+	if hex.DecodedLen(len(data)) > len(h) {
+		return fmt.Errorf("hash '%s' is longer than %d bytes", data, len(h))
+	}
+
 	_, err := hex.Decode(h[:], data)
 	return err
 }
@@ -41,8 +42,12 @@ type BlockHeader struct {
 	Parent Hash   `json:"parent"`
 	Number uint64 `json:"number"`
 	Nonce uint32   `json:"nonce"`
+	Difficulty uint64 `json:"difficulty"`
 	Time   uint64 `json:"time"`
 	Miner common.Address `json:"miner"`
+	Fees uint `json:"fees"`
+	TxRoot Hash `json:"tx_root"`
+	StateRoot Hash `json:"state_root"`
 }
 
 type BlockFs struct {
@@ -50,21 +55,20 @@ type BlockFs struct {
 	Value Block `json:"block"`
 }
 
-func NewBlock(parent Hash, number uint64, nonce uint32, time uint64, miner common.Address, txs []SignedTx) Block {
-	return Block{BlockHeader{parent, number, nonce,time, miner}, txs}
+func NewBlock(parent Hash, number uint64, nonce uint32, difficulty uint64, time uint64, miner common.Address, stateRoot Hash, txs []SignedTx) (Block, error) {
+	txRoot, err := TxRoot(txs)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return Block{BlockHeader{parent, number, nonce, difficulty, time, miner, TotalFees(txs), txRoot, stateRoot}, txs}, nil
 }
 
+// Hash only covers the header, the txs are committed to by its TxRoot.
 func (b Block) Hash() (Hash, error) {
-	blockjson, err := json.Marshal(b)
+	headerRaw, err := b.Header.Encode()
 	if err != nil {
 		return Hash{}, err
 	}
-	return sha256.Sum256(blockjson), nil
+	return sha256.Sum256(headerRaw), nil
 }
-
-func IsBlockHashValid(hash Hash) bool {
-	return fmt.Sprintf("%x", hash[0]) == "0" &&
-		fmt.Sprintf("%x", hash[1]) == "0" &&
-		fmt.Sprintf("%x", hash[2]) == "0" &&
-		fmt.Sprintf("%x", hash[3]) != "0"
-}
\ No newline at end of file
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// indexMagic prefixes block.idx so indexes of an older layout get rebuilt.
+var indexMagic = []byte("TUBIDX02")
+
+// indexRecordSize is the size of one block.idx record:
+// 32 bytes block hash, 32 bytes parent hash, 8 bytes block number,
+// 8 bytes block.db offset and 4 bytes length of the stored record.
+const indexRecordSize = 32 + 32 + 8 + 8 + 4
+
+type blockLocation struct {
+	parent Hash
+	number uint64
+	offset int64
+	length uint32
+}
+
+// blockIndex maps block hashes to their position inside block.db and the
+// heights of the canonical chain to block hashes, so single blocks can be
+// read without scanning the whole file. Blocks of side branches are indexed
+// by hash only.
+type blockIndex struct {
+	mu       sync.RWMutex
+	file     *os.File
+	byHash   map[Hash]blockLocation
+	byHeight map[uint64]Hash
+	head     Hash
+	last     blockLocation
+	count    int
+}
+
+func openBlockIndex(path string) (*blockIndex, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &blockIndex{file: f, byHash: make(map[Hash]blockLocation), byHeight: make(map[uint64]Hash)}
+
+	if err := idx.load(); err != nil {
+		fmt.Printf("Block index is invalid, rebuilding it. %s\n", err)
+
+		if err := idx.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+func (idx *blockIndex) load() error {
+	content, err := ioutil.ReadAll(idx.file)
+	if err != nil {
+		return err
+	}
+
+	if len(content) == 0 {
+		_, err := idx.file.Write(indexMagic)
+		return err
+	}
+
+	if !bytes.HasPrefix(content, indexMagic) {
+		return fmt.Errorf("unknown index layout")
+	}
+	content = content[len(indexMagic):]
+
+	if len(content)%indexRecordSize != 0 {
+		return fmt.Errorf("index size %d is not a multiple of %d", len(content), indexRecordSize)
+	}
+
+	for i := 0; i < len(content); i += indexRecordSize {
+		var hash Hash
+		copy(hash[:], content[i:i+32])
+
+		loc := blockLocation{
+			number: binary.BigEndian.Uint64(content[i+64 : i+72]),
+			offset: int64(binary.BigEndian.Uint64(content[i+72 : i+80])),
+			length: binary.BigEndian.Uint32(content[i+80 : i+84]),
+		}
+		copy(loc.parent[:], content[i+32:i+64])
+
+		idx.insert(hash, loc)
+	}
+
+	return nil
+}
+
+func (idx *blockIndex) reset() error {
+	if err := idx.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := idx.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := idx.file.Write(indexMagic); err != nil {
+		return err
+	}
+
+	idx.byHash = make(map[Hash]blockLocation)
+	idx.byHeight = make(map[uint64]Hash)
+	idx.head = Hash{}
+	idx.last = blockLocation{}
+	idx.count = 0
+
+	return nil
+}
+
+// end returns the block.db offset right after the last indexed block.
+func (idx *blockIndex) end() int64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.count == 0 {
+		return 0
+	}
+
+	return idx.last.offset + int64(idx.last.length)
+}
+
+func (idx *blockIndex) insert(hash Hash, loc blockLocation) {
+	idx.byHash[hash] = loc
+	idx.last = loc
+	idx.count++
+}
+
+func (idx *blockIndex) add(hash Hash, parent Hash, number uint64, offset int64, length uint32) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	loc := blockLocation{parent, number, offset, length}
+
+	record := make([]byte, indexRecordSize)
+	copy(record[0:32], hash[:])
+	copy(record[32:64], parent[:])
+	binary.BigEndian.PutUint64(record[64:72], loc.number)
+	binary.BigEndian.PutUint64(record[72:80], uint64(loc.offset))
+	binary.BigEndian.PutUint32(record[80:84], loc.length)
+
+	idx.insert(hash, loc)
+
+	_, err := idx.file.Write(record)
+
+	return err
+}
+
+// setHead makes the chain ending with head the canonical one by walking its
+// parents back until they meet the current canonical chain.
+func (idx *blockIndex) setHead(head Hash) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	headLoc, ok := idx.byHash[head]
+	if !ok {
+		return fmt.Errorf("%w: hash '%s'", ErrBlockNotFound, head.Hex())
+	}
+
+	changes := make(map[uint64]Hash)
+	for hash := head; !hash.IsEmpty(); {
+		loc, ok := idx.byHash[hash]
+		if !ok {
+			return fmt.Errorf("%w: hash '%s'", ErrBlockNotFound, hash.Hex())
+		}
+
+		if canonical, ok := idx.byHeight[loc.number]; ok && canonical == hash {
+			break
+		}
+
+		changes[loc.number] = hash
+		hash = loc.parent
+	}
+
+	if !idx.head.IsEmpty() {
+		for number := headLoc.number + 1; number <= idx.byHash[idx.head].number; number++ {
+			delete(idx.byHeight, number)
+		}
+	}
+
+	for number, hash := range changes {
+		idx.byHeight[number] = hash
+	}
+	idx.head = head
+
+	return nil
+}
+
+// highestBlock returns the indexed block with the highest number, used as
+// head when none was persisted yet.
+func (idx *blockIndex) highestBlock() (Hash, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var highest Hash
+	var highestNumber uint64
+	found := false
+	for hash, loc := range idx.byHash {
+		if !found || loc.number > highestNumber {
+			highest, highestNumber, found = hash, loc.number, true
+		}
+	}
+
+	return highest, found
+}
+
+func (idx *blockIndex) locationByHash(hash Hash) (blockLocation, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	loc, ok := idx.byHash[hash]
+
+	return loc, ok
+}
+
+func (idx *blockIndex) hashByHeight(number uint64) (Hash, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	hash, ok := idx.byHeight[number]
+
+	return hash, ok
+}
+
+// latestNumber returns the number of the canonical head and false when no
+// head is set yet.
+func (idx *blockIndex) latestNumber() (uint64, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.head.IsEmpty() {
+		return 0, false
+	}
+
+	return idx.byHash[idx.head].number, true
+}
+
+func (idx *blockIndex) close() error {
+	return idx.file.Close()
+}
+
+func readBlockAt(dbFile *os.File, loc blockLocation) (BlockFs, error) {
+	record := make([]byte, loc.length)
+	if _, err := dbFile.ReadAt(record, loc.offset); err != nil {
+		return BlockFs{}, err
+	}
+
+	return decodeBlockRecord(record)
+}
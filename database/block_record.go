@@ -0,0 +1,111 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// checksumLength is the length of the hex encoded CRC-32 prefixing every
+// block.db record, followed by a single space and the hex encoded BlockFs.
+const checksumLength = 8
+
+func encodeBlockRecord(blockFs BlockFs) ([]byte, error) {
+	blockFsRaw, err := blockFs.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	content := []byte(hex.EncodeToString(blockFsRaw))
+
+	record := make([]byte, 0, checksumLength+1+len(content)+1)
+	record = append(record, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(content))...)
+	record = append(record, content...)
+
+	return append(record, '\n'), nil
+}
+
+// decodeBlockRecord validates and decodes a single block.db record.
+// Records written before checksums were introduced are plain JSON lines and
+// records written before the binary encoding hold JSON after their checksum,
+// both are accepted as they are.
+func decodeBlockRecord(record []byte) (BlockFs, error) {
+	if len(record) == 0 || record[len(record)-1] != '\n' {
+		return BlockFs{}, fmt.Errorf("block record is not terminated by a new line")
+	}
+	record = record[:len(record)-1]
+
+	content := record
+	if !bytes.HasPrefix(record, []byte("{")) {
+		if len(record) < checksumLength+1 || record[checksumLength] != ' ' {
+			return BlockFs{}, fmt.Errorf("block record has no checksum")
+		}
+
+		checksum, err := hex.DecodeString(string(record[:checksumLength]))
+		if err != nil {
+			return BlockFs{}, fmt.Errorf("block record has an invalid checksum. %s", err.Error())
+		}
+
+		content = record[checksumLength+1:]
+		expected := crc32.ChecksumIEEE(content)
+		actual := binary.BigEndian.Uint32(checksum)
+
+		if actual != expected {
+			return BlockFs{}, fmt.Errorf("block record checksum is '%08x' but content hashes to '%08x'", actual, expected)
+		}
+	}
+
+	if bytes.HasPrefix(content, []byte("{")) {
+		var blockFs BlockFs
+		if err := json.Unmarshal(content, &blockFs); err != nil {
+			return BlockFs{}, fmt.Errorf("unable to unmarshal block record. %s", err.Error())
+		}
+
+		return blockFs, nil
+	}
+
+	blockFsRaw, err := hex.DecodeString(string(content))
+	if err != nil {
+		return BlockFs{}, fmt.Errorf("block record is not hex encoded. %s", err.Error())
+	}
+
+	return decodeBlockFs(blockFsRaw)
+}
+
+// scanBlockRecords calls fn for every valid record of f starting at offset
+// and returns the offset where the valid records end. Reading stops at the
+// first torn or corrupted record instead of failing.
+func scanBlockRecords(f *os.File, offset int64, fn func(blockFs BlockFs, offset int64, length uint32) error) (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, stat.Size()-offset))
+	for {
+		record, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		blockFs, err := decodeBlockRecord(record)
+		if err != nil {
+			return offset, nil
+		}
+
+		if err := fn(blockFs, offset, uint32(len(record))); err != nil {
+			return offset, err
+		}
+
+		offset += int64(len(record))
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BackendFile stores the blocks as checksummed records appended to block.db.
+// It was named after the JSON lines it held before, backendJsonlAlias is
+// still accepted for it.
+const BackendFile = "file"
+const backendJsonlAlias = "jsonl"
+const BackendLevelDb = "leveldb"
+const DefaultBackend = BackendFile
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// BlockStore persists blocks of every known branch and serves them back by
+// hash, or by height along the canonical chain selected with SetHead.
+// Iterate replays every stored block in the order it was appended.
+type BlockStore interface {
+	Append(blockFs BlockFs) error
+	SetHead(head Hash) error
+	Iterate(fn func(blockFs BlockFs) error) error
+	GetByHash(hash Hash) (Block, error)
+	GetByHeight(number uint64) (Block, error)
+	Close() error
+}
+
+// DetectBackend returns the backend already holding the blocks of the data dir,
+// falling back to the DefaultBackend for a fresh one.
+func DetectBackend(dataDir string) string {
+	if fileExist(getBlocksLevelDbDirPath(dataDir)) {
+		return BackendLevelDb
+	}
+
+	return DefaultBackend
+}
+
+// NormalizeBackend returns the backend named backend, resolving its aliases.
+func NormalizeBackend(backend string) string {
+	if backend == backendJsonlAlias {
+		return BackendFile
+	}
+
+	return backend
+}
+
+func openBlockStore(dataDir string, backend string) (BlockStore, error) {
+	backend = NormalizeBackend(backend)
+	detected := DetectBackend(dataDir)
+	if detected != backend && detected != DefaultBackend {
+		return nil, fmt.Errorf("data dir '%s' already stores blocks using the '%s' backend", dataDir, detected)
+	}
+
+	switch backend {
+	case BackendFile:
+		return openFileBlockStore(dataDir)
+	case BackendLevelDb:
+		hasBlocks, err := fileBlockStoreHasBlocks(dataDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if hasBlocks {
+			return nil, fmt.Errorf("data dir '%s' already stores blocks using the '%s' backend", dataDir, BackendFile)
+		}
+
+		return openLevelDbBlockStore(dataDir)
+	default:
+		return nil, fmt.Errorf("unknown database backend '%s'. Use '%s' or '%s'", backend, BackendFile, BackendLevelDb)
+	}
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBlockStore_Backends(t *testing.T) {
+	for _, backend := range []string{BackendFile, backendJsonlAlias, BackendLevelDb} {
+		t.Run(backend, func(t *testing.T) {
+			dataDir := setUpTestDataDir(t)
+			defer os.RemoveAll(dataDir)
+
+			store, err := openBlockStore(dataDir, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blocks := createTestBlocks(t, 3)
+			for _, blockFs := range blocks {
+				if err := store.Append(blockFs); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := store.SetHead(blocks[len(blocks)-1].Key); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			store, err = openBlockStore(dataDir, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			for _, blockFs := range blocks {
+				byHash, err := store.GetByHash(blockFs.Key)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				byHeight, err := store.GetByHeight(blockFs.Value.Header.Number)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if byHash.Header != blockFs.Value.Header || byHeight.Header != blockFs.Value.Header {
+					t.Fatalf("block %d was not stored as appended", blockFs.Value.Header.Number)
+				}
+			}
+
+			iterated := 0
+			err = store.Iterate(func(blockFs BlockFs) error {
+				if blockFs.Key != blocks[iterated].Key {
+					t.Fatalf("block %d was iterated out of append order", iterated)
+				}
+				iterated++
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if iterated != len(blocks) {
+				t.Fatalf("iterated %d blocks instead of %d", iterated, len(blocks))
+			}
+
+			if _, err := store.GetByHeight(uint64(len(blocks))); !errors.Is(err, ErrBlockNotFound) {
+				t.Fatalf("missing block should return ErrBlockNotFound, got %v", err)
+			}
+		})
+	}
+}
@@ -1,45 +1,184 @@
 package database
 
 import (
-	"bufio"
-	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
 	"reflect"
 )
 
-func GetBlocksAfter(blockHash Hash, dataDir string) ([]Block, error) {
-	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_RDONLY, 0600)
-	if err != nil {
-		return nil, err
-	}
+func (s *State) GetBlockByHash(hash Hash) (Block, error) {
+	return s.store.GetByHash(hash)
+}
+
+func (s *State) GetBlockByNumber(number uint64) (Block, error) {
+	return s.store.GetByHeight(number)
+}
 
+// GetBlocksAfter returns up to limit canonical blocks following blockHash.
+func (s *State) GetBlocksAfter(blockHash Hash, limit int) ([]Block, error) {
 	blocks := make([]Block, 0)
-	shouldStartCollecting := false
 
-	if reflect.DeepEqual(blockHash, Hash{}) {
-		shouldStartCollecting = true
+	if !s.hasGenesisBlock {
+		return blocks, nil
 	}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	from := uint64(0)
+	if !reflect.DeepEqual(blockHash, Hash{}) {
+		block, err := s.GetBlockByHash(blockHash)
+		if errors.Is(err, ErrBlockNotFound) {
+			return blocks, nil
 		}
-		var blockFs BlockFs
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
 		if err != nil {
 			return nil, err
 		}
 
-		if shouldStartCollecting {
-			blocks = append(blocks, blockFs.Value)
-			continue
+		canonical, err := s.GetBlockByNumber(block.Header.Number)
+		if err != nil && !errors.Is(err, ErrBlockNotFound) {
+			return nil, err
 		}
 
-		if blockHash == blockFs.Key {
-			shouldStartCollecting = true
+		if err != nil || !reflect.DeepEqual(canonical.Header, block.Header) {
+			return blocks, nil
 		}
+
+		from = block.Header.Number + 1
+	}
+
+	for number := from; number <= s.latestBlock.Header.Number && len(blocks) < limit; number++ {
+		block, err := s.GetBlockByNumber(number)
+		if errors.Is(err, ErrBlockNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
 	}
 
 	return blocks, nil
 }
+
+// BlockInfo is a block along with its hash and the number of canonical
+// blocks confirming it, itself included. Blocks of side branches have no
+// confirmations.
+type BlockInfo struct {
+	Hash          Hash   `json:"hash"`
+	Block         Block  `json:"block"`
+	Confirmations uint64 `json:"confirmations"`
+}
+
+// TxInfo locates a tx in the canonical chain.
+type TxInfo struct {
+	Hash          Hash     `json:"hash"`
+	Tx            SignedTx `json:"tx"`
+	BlockHash     Hash     `json:"block_hash"`
+	BlockNumber   uint64   `json:"block_number"`
+	Index         int      `json:"index"`
+	Confirmations uint64   `json:"confirmations"`
+}
+
+// confirmations returns how many canonical blocks confirm block b with hash,
+// 0 if it is not part of the canonical chain.
+func (s *State) confirmations(hash Hash, b Block) (uint64, error) {
+	if !s.hasGenesisBlock || b.Header.Number > s.latestBlock.Header.Number {
+		return 0, nil
+	}
+
+	canonical, err := s.GetBlockByNumber(b.Header.Number)
+	if errors.Is(err, ErrBlockNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	canonicalHash, err := canonical.Hash()
+	if err != nil {
+		return 0, err
+	}
+
+	if canonicalHash != hash {
+		return 0, nil
+	}
+
+	return s.latestBlock.Header.Number - b.Header.Number + 1, nil
+}
+
+func (s *State) blockInfo(b Block) (BlockInfo, error) {
+	hash, err := b.Hash()
+	if err != nil {
+		return BlockInfo{}, err
+	}
+
+	confirmations, err := s.confirmations(hash, b)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+
+	return BlockInfo{hash, b, confirmations}, nil
+}
+
+func (s *State) GetBlockInfo(hash Hash) (BlockInfo, error) {
+	b, err := s.GetBlockByHash(hash)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+
+	return s.blockInfo(b)
+}
+
+func (s *State) GetBlockInfoByNumber(number uint64) (BlockInfo, error) {
+	b, err := s.GetBlockByNumber(number)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+
+	return s.blockInfo(b)
+}
+
+func (s *State) LatestBlockInfo() (BlockInfo, error) {
+	if !s.hasGenesisBlock {
+		return BlockInfo{}, fmt.Errorf("%w: the chain has no blocks yet", ErrBlockNotFound)
+	}
+
+	return s.blockInfo(s.latestBlock)
+}
+
+// findTx looks the tx with hash txHash up in the account index and returns
+// its canonical block and index in it.
+func (s *State) findTx(txHash Hash) (Block, int, error) {
+	if s.accountIndex == nil {
+		return Block{}, 0, fmt.Errorf("the account index is not open")
+	}
+
+	loc, ok := s.accountIndex.txLocation(txHash)
+	if !ok {
+		return Block{}, 0, fmt.Errorf("%w: tx '%s'", ErrTxNotFound, txHash.Hex())
+	}
+
+	b, err := s.GetBlockByHash(loc.blockHash)
+	if err != nil {
+		return Block{}, 0, err
+	}
+
+	if int(loc.index) >= len(b.Txs) {
+		return Block{}, 0, fmt.Errorf("block '%s' has no tx at index %d, rebuild the account index", loc.blockHash.Hex(), loc.index)
+	}
+
+	return b, int(loc.index), nil
+}
+
+func (s *State) GetTxInfo(txHash Hash) (TxInfo, error) {
+	b, index, err := s.findTx(txHash)
+	if err != nil {
+		return TxInfo{}, err
+	}
+
+	info, err := s.blockInfo(b)
+	if err != nil {
+		return TxInfo{}, err
+	}
+
+	return TxInfo{txHash, b.Txs[index], info.Hash, b.Header.Number, index, info.Confirmations}, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// appendTestChain appends blocks holding txs of blockTxs to store, each
+// block the child of the previous one.
+func appendTestChain(t *testing.T, store BlockStore, blockTxs [][]SignedTx) []BlockFs {
+	blocks := make([]BlockFs, 0, len(blockTxs))
+	parent := Hash{}
+	for number, txs := range blockTxs {
+		b, err := NewBlock(parent, uint64(number), 0, 1, uint64(number), NewAccount("0x9"), Hash{}, txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Append(BlockFs{hash, b}); err != nil {
+			t.Fatal(err)
+		}
+
+		blocks = append(blocks, BlockFs{hash, b})
+		parent = hash
+	}
+
+	if err := store.SetHead(parent); err != nil {
+		t.Fatal(err)
+	}
+
+	return blocks
+}
+
+func TestGetTxInfo(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	store, err := openBlockStore(dataDir, BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	txs := createTestTxs(2)
+	blocks := appendTestChain(t, store, [][]SignedTx{nil, txs, nil})
+
+	side, err := NewBlock(blocks[1].Key, 2, 1, 1, 2, NewAccount("0x8"), Hash{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sideHash, err := side.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(BlockFs{sideHash, side}); err != nil {
+		t.Fatal(err)
+	}
+
+	head := blocks[len(blocks)-1]
+
+	idx, err := openAccountIndex(getAccountIndexFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.close()
+
+	s := &State{store: store, latestBlock: head.Value, latestBlockHash: head.Key, hasGenesisBlock: true, accountIndex: idx}
+	if err := s.syncAccountIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, err := txs[1].Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.GetTxInfo(txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.BlockHash != blocks[1].Key || info.BlockNumber != 1 || info.Index != 1 || info.Confirmations != 2 {
+		t.Fatalf("tx should be the 2nd of block 1 with 2 confirmations, got %+v", info)
+	}
+
+	if _, err := s.GetTxInfo(Hash{1}); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("unknown tx should not be found, got %v", err)
+	}
+
+	latest, err := s.LatestBlockInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if latest.Hash != head.Key || latest.Confirmations != 1 {
+		t.Fatalf("latest block should have 1 confirmation, got %+v", latest)
+	}
+
+	sideInfo, err := s.GetBlockInfo(sideHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sideInfo.Confirmations != 0 {
+		t.Fatalf("side block should have no confirmations, got %d", sideInfo.Confirmations)
+	}
+}
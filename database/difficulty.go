@@ -0,0 +1,111 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// DefaultDifficulty matches the former rule of hashes starting with 3 zero
+// bytes, 2^24 attempts on average.
+const DefaultDifficulty = 1 << 24
+
+// DefaultTargetBlockTime is the block time in seconds the difficulty is
+// retargeted towards.
+const DefaultTargetBlockTime = 60
+
+// The difficulty is retargeted every block from the time the last
+// retargetWindow blocks took, by at most maxRetargetFactor at once.
+const retargetWindow = 10
+const maxRetargetFactor = 4
+
+var maxHash = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// IsBlockHashValid tells whether hash is below the target of difficulty,
+// i.e. whether it took difficulty attempts on average to find.
+func IsBlockHashValid(hash Hash, difficulty uint64) bool {
+	if difficulty == 0 {
+		return false
+	}
+
+	target := new(big.Int).Div(maxHash, new(big.Int).SetUint64(difficulty))
+
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) < 0
+}
+
+// NextDifficulty returns the difficulty a block mined on top of parent must
+// have. An empty parent stands for the first block of the chain.
+func (s *State) NextDifficulty(parent Hash) (uint64, error) {
+	if parent.IsEmpty() {
+		return s.genesis.InitialDifficulty(), nil
+	}
+
+	b, err := s.store.GetByHash(parent)
+	if err != nil {
+		return 0, err
+	}
+
+	newest := b.Header
+	oldest := newest
+	for i := 0; i < retargetWindow && !oldest.Parent.IsEmpty(); i++ {
+		b, err := s.store.GetByHash(oldest.Parent)
+		if err != nil {
+			return 0, err
+		}
+		oldest = b.Header
+	}
+
+	if newest.Number <= oldest.Number {
+		return newest.Difficulty, nil
+	}
+
+	return retarget(newest.Difficulty, newest.Number-oldest.Number, newest.Time, oldest.Time, s.genesis.BlockTime()), nil
+}
+
+func retarget(difficulty uint64, blocks uint64, newestTime uint64, oldestTime uint64, targetBlockTime uint64) uint64 {
+	expected := new(big.Int).SetUint64(blocks * targetBlockTime)
+
+	actual := new(big.Int)
+	if newestTime > oldestTime {
+		actual.SetUint64(newestTime - oldestTime)
+	}
+
+	minActual := new(big.Int).Div(expected, big.NewInt(maxRetargetFactor))
+	maxActual := new(big.Int).Mul(expected, big.NewInt(maxRetargetFactor))
+	if actual.Cmp(minActual) < 0 {
+		actual.Set(minActual)
+	}
+	if actual.Cmp(maxActual) > 0 {
+		actual.Set(maxActual)
+	}
+	if actual.Sign() == 0 {
+		actual.SetInt64(1)
+	}
+
+	next := new(big.Int).SetUint64(difficulty)
+	next.Mul(next, expected)
+	next.Div(next, actual)
+
+	if next.Sign() == 0 {
+		return 1
+	}
+
+	if !next.IsUint64() {
+		return math.MaxUint64
+	}
+
+	return next.Uint64()
+}
+
+func (s *State) validateDifficulty(b Block) error {
+	expected, err := s.NextDifficulty(b.Header.Parent)
+	if err != nil {
+		return err
+	}
+
+	if b.Header.Difficulty != expected {
+		return fmt.Errorf("block difficulty must be '%d' not '%d'", expected, b.Header.Difficulty)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsBlockHashValid(t *testing.T) {
+	hash := Hash{0, 0, 0, 0xff}
+	if !IsBlockHashValid(hash, DefaultDifficulty) {
+		t.Fatal("hash starting with 3 zero bytes should be valid for the default difficulty")
+	}
+
+	if IsBlockHashValid(hash, DefaultDifficulty<<1) {
+		t.Fatal("hash starting with 3 zero bytes should be invalid for twice the default difficulty")
+	}
+
+	if IsBlockHashValid(Hash{}, 0) {
+		t.Fatal("a difficulty of 0 should never be valid")
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	cases := []struct {
+		name     string
+		actual   uint64
+		expected uint64
+	}{
+		{"on target", 600, 1000},
+		{"twice too slow", 1200, 500},
+		{"twice too fast", 300, 2000},
+		{"clamped when way too fast", 1, 4000},
+		{"clamped when way too slow", 100000, 250},
+	}
+
+	for _, c := range cases {
+		next := retarget(1000, retargetWindow, 1000+c.actual, 1000, 60)
+		if next != c.expected {
+			t.Errorf("%s: difficulty should be %d, got %d", c.name, c.expected, next)
+		}
+	}
+
+	if next := retarget(1, 1, 1000, 1000+1, 60); next != 4 {
+		t.Errorf("going back in time should count as the fastest possible blocks, got %d", next)
+	}
+}
+
+func TestNextDifficulty(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	store, err := openBlockStore(dataDir, BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// createTestBlocks mines blocks 1 second apart.
+	blocks := createTestBlocks(t, retargetWindow+2)
+	for _, blockFs := range blocks {
+		if err := store.Append(blockFs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &State{store: store, genesis: Genesis{Difficulty: 100, TargetBlockTime: 1}}
+
+	difficulty, err := s.NextDifficulty(Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if difficulty != 100 {
+		t.Fatalf("first block should have the genesis difficulty, got %d", difficulty)
+	}
+
+	difficulty, err = s.NextDifficulty(blocks[len(blocks)-1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if difficulty != DefaultDifficulty {
+		t.Fatalf("blocks on target should keep their difficulty, got %d", difficulty)
+	}
+
+	s.genesis.TargetBlockTime = 2
+	difficulty, err = s.NextDifficulty(blocks[len(blocks)-1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if difficulty != 2*DefaultDifficulty {
+		t.Fatalf("blocks twice too fast should double the difficulty, got %d", difficulty)
+	}
+}
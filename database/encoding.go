@@ -0,0 +1,292 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Txs and blocks are hashed, signed, stored and sent to peers in their
+// canonical RLP encoding, JSON is only used by the HTTP API:
+//
+//	tx        = [version, to, from, nonce, value, fee, reason, time, chain_id]
+//	tx v2     = [version, to, from, nonce, value, fee, reason, time, chain_id, asset, lock_height, lock_time, threshold, [signer, ...], kind]
+//	signed tx = [tx, signature, cosignature, ...]
+//	header    = [version, parent, number, nonce, difficulty, time, miner, fees, tx_root, state_root]
+//	block     = [header, [signed tx, ...]]
+//
+// Numbers are big endian without leading zeros as RLP mandates. Any change
+// to these lists must come with a new EncodingVersion.
+//
+// Txs using the optional fields added since are encoded with TxExtVersion and
+// the fields appended to the list. Trailing empty fields are left out, so a
+// tx without any keeps its version 1 encoding and hash.
+const EncodingVersion = 1
+const TxExtVersion = 2
+
+type txRLP struct {
+	Version uint
+	To      common.Address
+	From    common.Address
+	Nonce   uint
+	Value   uint
+	Fee     uint
+	Reason  string
+	Time    uint64
+	ChainId string
+	Ext     []rlp.RawValue `rlp:"tail"`
+}
+
+type signedTxRLP struct {
+	Tx   txRLP
+	Sig  []byte
+	Sigs [][]byte `rlp:"tail"`
+}
+
+type headerRLP struct {
+	Version    uint
+	Parent     Hash
+	Number     uint64
+	Nonce      uint32
+	Difficulty uint64
+	Time       uint64
+	Miner      common.Address
+	Fees       uint
+	TxRoot     Hash
+	StateRoot  Hash
+}
+
+type blockRLP struct {
+	Header headerRLP
+	Txs    []signedTxRLP
+}
+
+type blockFsRLP struct {
+	Key   Hash
+	Value blockRLP
+}
+
+func checkEncodingVersion(version uint) error {
+	if version != EncodingVersion {
+		return fmt.Errorf("unsupported encoding version %d, expected %d", version, EncodingVersion)
+	}
+
+	return nil
+}
+
+func (tx Tx) toRLP() txRLP {
+	r := txRLP{EncodingVersion, tx.To, tx.From, tx.Nonce, tx.Value, tx.Fee, tx.Reason, tx.Time, tx.ChainId, nil}
+
+	r.Ext = encodeTxExt(tx.Asset, tx.LockHeight, tx.LockTime, tx.Threshold, tx.Signers, tx.Kind)
+	if len(r.Ext) > 0 {
+		r.Version = TxExtVersion
+	}
+
+	return r
+}
+
+func (r txRLP) toTx() (Tx, error) {
+	tx := Tx{To: r.To, From: r.From, Nonce: r.Nonce, Value: r.Value, Fee: r.Fee, Reason: r.Reason, Time: r.Time, ChainId: r.ChainId}
+
+	switch r.Version {
+	case EncodingVersion:
+		if len(r.Ext) > 0 {
+			return Tx{}, fmt.Errorf("version %d tx has %d unexpected trailing fields", r.Version, len(r.Ext))
+		}
+	case TxExtVersion:
+		if err := decodeTxExt(r.Ext, &tx.Asset, &tx.LockHeight, &tx.LockTime, &tx.Threshold, &tx.Signers, &tx.Kind); err != nil {
+			return Tx{}, err
+		}
+	default:
+		return Tx{}, fmt.Errorf("unsupported tx encoding version %d, expected %d or %d", r.Version, EncodingVersion, TxExtVersion)
+	}
+
+	return tx, nil
+}
+
+// emptyRLPString is the encoding of both an empty string and zero,
+// emptyRLPList the one of an empty list.
+const emptyRLPString = 0x80
+const emptyRLPList = 0xc0
+
+// encodeTxExt encodes the optional tx fields, in order, without the
+// trailing empty ones.
+func encodeTxExt(fields ...interface{}) []rlp.RawValue {
+	ext := make([]rlp.RawValue, len(fields))
+	for i, field := range fields {
+		value, err := rlp.EncodeToBytes(field)
+		if err != nil {
+			panic(fmt.Sprintf("unable to encode tx field %d. %s", i, err.Error()))
+		}
+		ext[i] = value
+	}
+
+	for len(ext) > 0 && isEmptyRLP(ext[len(ext)-1]) {
+		ext = ext[:len(ext)-1]
+	}
+
+	return ext
+}
+
+// decodeTxExt decodes the optional fields of a TxExtVersion tx, which must
+// end with a non empty one to keep the encoding canonical.
+func decodeTxExt(ext []rlp.RawValue, fields ...interface{}) error {
+	if len(ext) == 0 || len(ext) > len(fields) {
+		return fmt.Errorf("version %d tx must have 1 to %d trailing fields, got %d", TxExtVersion, len(fields), len(ext))
+	}
+
+	if isEmptyRLP(ext[len(ext)-1]) {
+		return fmt.Errorf("version %d tx has a non canonical empty trailing field", TxExtVersion)
+	}
+
+	for i, value := range ext {
+		if err := rlp.DecodeBytes(value, fields[i]); err != nil {
+			return fmt.Errorf("unable to decode tx field %d. %s", i, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func isEmptyRLP(value rlp.RawValue) bool {
+	return len(value) == 1 && (value[0] == emptyRLPString || value[0] == emptyRLPList)
+}
+
+func (tx SignedTx) toRLP() signedTxRLP {
+	return signedTxRLP{tx.Tx.toRLP(), tx.Sig, tx.Sigs}
+}
+
+func (r signedTxRLP) toSignedTx() (SignedTx, error) {
+	tx, err := r.Tx.toTx()
+	if err != nil {
+		return SignedTx{}, err
+	}
+
+	signed := NewSignedTx(tx, r.Sig)
+	if len(r.Sigs) > 0 {
+		signed.Sigs = r.Sigs
+	}
+
+	return signed, nil
+}
+
+func (h BlockHeader) toRLP() headerRLP {
+	return headerRLP{EncodingVersion, h.Parent, h.Number, h.Nonce, h.Difficulty, h.Time, h.Miner, h.Fees, h.TxRoot, h.StateRoot}
+}
+
+func (r headerRLP) toHeader() (BlockHeader, error) {
+	if err := checkEncodingVersion(r.Version); err != nil {
+		return BlockHeader{}, err
+	}
+
+	return BlockHeader{r.Parent, r.Number, r.Nonce, r.Difficulty, r.Time, r.Miner, r.Fees, r.TxRoot, r.StateRoot}, nil
+}
+
+func (b Block) toRLP() blockRLP {
+	txs := make([]signedTxRLP, len(b.Txs))
+	for i, tx := range b.Txs {
+		txs[i] = tx.toRLP()
+	}
+
+	return blockRLP{b.Header.toRLP(), txs}
+}
+
+func (r blockRLP) toBlock() (Block, error) {
+	header, err := r.Header.toHeader()
+	if err != nil {
+		return Block{}, err
+	}
+
+	txs := make([]SignedTx, len(r.Txs))
+	for i, txRLP := range r.Txs {
+		tx, err := txRLP.toSignedTx()
+		if err != nil {
+			return Block{}, err
+		}
+		txs[i] = tx
+	}
+
+	return Block{header, txs}, nil
+}
+
+// Encode returns the canonical encoding of the tx, without signature, which
+// is hashed and signed.
+func (tx Tx) Encode() ([]byte, error) {
+	return rlp.EncodeToBytes(tx.toRLP())
+}
+
+func (tx SignedTx) Encode() ([]byte, error) {
+	return rlp.EncodeToBytes(tx.toRLP())
+}
+
+func DecodeSignedTx(data []byte) (SignedTx, error) {
+	var r signedTxRLP
+	if err := rlp.DecodeBytes(data, &r); err != nil {
+		return SignedTx{}, fmt.Errorf("unable to decode tx. %s", err.Error())
+	}
+
+	return r.toSignedTx()
+}
+
+func (h BlockHeader) Encode() ([]byte, error) {
+	return rlp.EncodeToBytes(h.toRLP())
+}
+
+func (b Block) Encode() ([]byte, error) {
+	return rlp.EncodeToBytes(b.toRLP())
+}
+
+func DecodeBlock(data []byte) (Block, error) {
+	var r blockRLP
+	if err := rlp.DecodeBytes(data, &r); err != nil {
+		return Block{}, fmt.Errorf("unable to decode block. %s", err.Error())
+	}
+
+	return r.toBlock()
+}
+
+// EncodeBlocks encodes a list of blocks, e.g. to send them to a peer.
+func EncodeBlocks(blocks []Block) ([]byte, error) {
+	list := make([]blockRLP, len(blocks))
+	for i, b := range blocks {
+		list[i] = b.toRLP()
+	}
+
+	return rlp.EncodeToBytes(list)
+}
+
+func DecodeBlocks(data []byte) ([]Block, error) {
+	var list []blockRLP
+	if err := rlp.DecodeBytes(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to decode blocks. %s", err.Error())
+	}
+
+	blocks := make([]Block, len(list))
+	for i, r := range list {
+		b, err := r.toBlock()
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = b
+	}
+
+	return blocks, nil
+}
+
+func (blockFs BlockFs) encode() ([]byte, error) {
+	return rlp.EncodeToBytes(blockFsRLP{blockFs.Key, blockFs.Value.toRLP()})
+}
+
+func decodeBlockFs(data []byte) (BlockFs, error) {
+	var r blockFsRLP
+	if err := rlp.DecodeBytes(data, &r); err != nil {
+		return BlockFs{}, fmt.Errorf("unable to decode block. %s", err.Error())
+	}
+
+	b, err := r.Value.toBlock()
+	if err != nil {
+		return BlockFs{}, err
+	}
+
+	return BlockFs{r.Key, b}, nil
+}
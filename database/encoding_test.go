@@ -0,0 +1,144 @@
+package database
+
+import (
+	"encoding/hex"
+	"github.com/ethereum/go-ethereum/rlp"
+	"reflect"
+	"testing"
+)
+
+func TestTxEncode_Canonical(t *testing.T) {
+	tx := Tx{To: NewAccount("0x02"), From: NewAccount("0x01"), Nonce: 1, Value: 5, Fee: 1, Reason: "rent", Time: 1600000000, ChainId: "bar"}
+
+	raw, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// [1, to, from, 1, 5, 1, "rent", 1600000000, "bar"]
+	expected := "f83c01" +
+		"940000000000000000000000000000000000000002" +
+		"940000000000000000000000000000000000000001" +
+		"010501" + "8472656e74" + "845f5e1000" + "83626172"
+
+	if hex.EncodeToString(raw) != expected {
+		t.Fatalf("tx should encode to\n%s\ngot\n%s", expected, hex.EncodeToString(raw))
+	}
+}
+
+func TestTxEncode_Asset(t *testing.T) {
+	tx := Tx{To: NewAccount("0x02"), From: NewAccount("0x01"), Nonce: 1, Value: 5, Fee: 1, Reason: "rent", Time: 1600000000, ChainId: "bar", Asset: "GOLD"}
+
+	raw, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// [2, to, from, 1, 5, 1, "rent", 1600000000, "bar", "GOLD"]
+	expected := "f84102" +
+		"940000000000000000000000000000000000000002" +
+		"940000000000000000000000000000000000000001" +
+		"010501" + "8472656e74" + "845f5e1000" + "83626172" + "84474f4c44"
+
+	if hex.EncodeToString(raw) != expected {
+		t.Fatalf("tx should encode to\n%s\ngot\n%s", expected, hex.EncodeToString(raw))
+	}
+
+	signed, err := NewSignedTx(tx, []byte{1}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeSignedTx(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Asset != "GOLD" {
+		t.Fatalf("decoded tx should keep its asset, got '%s'", decoded.Asset)
+	}
+
+	mint := tx
+	mint.Kind = TxKindMint
+	mintRaw, err := NewSignedTx(mint, []byte{1}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err = DecodeSignedTx(mintRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.IsMint() {
+		t.Fatal("decoded tx should keep its mint kind")
+	}
+
+	mintHash, _ := mint.Hash()
+	txHash, _ := tx.Hash()
+	if mintHash == txHash {
+		t.Fatal("the kind of a tx should be part of its hash")
+	}
+
+	tx.Asset = ""
+	for _, r := range []txRLP{
+		{EncodingVersion, tx.To, tx.From, tx.Nonce, tx.Value, tx.Fee, tx.Reason, tx.Time, tx.ChainId, []rlp.RawValue{{0x84, 'G', 'O', 'L', 'D'}}},
+		{TxExtVersion, tx.To, tx.From, tx.Nonce, tx.Value, tx.Fee, tx.Reason, tx.Time, tx.ChainId, []rlp.RawValue{{emptyRLPString}}},
+		{TxExtVersion, tx.To, tx.From, tx.Nonce, tx.Value, tx.Fee, tx.Reason, tx.Time, tx.ChainId, nil},
+	} {
+		raw, err := rlp.EncodeToBytes(signedTxRLP{r, []byte{1}, nil})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := DecodeSignedTx(raw); err == nil {
+			t.Fatalf("non canonical version %d tx with %d trailing fields should be rejected", r.Version, len(r.Ext))
+		}
+	}
+}
+
+func TestBlockEncode_RoundTrip(t *testing.T) {
+	b, err := NewBlock(Hash{1}, 7, 42, 1000, 1600000000, NewAccount("0x09"), Hash{2}, createTestTxs(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := b.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeBlock(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, b) {
+		t.Fatalf("decoded block should equal the original\n%+v\n%+v", decoded, b)
+	}
+
+	blocksRaw, err := EncodeBlocks([]Block{b, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := DecodeBlocks(blocksRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != 2 || !reflect.DeepEqual(blocks[1], b) {
+		t.Fatal("decoded blocks should equal the original ones")
+	}
+
+	header := b.Header.toRLP()
+	header.Version = EncodingVersion + 1
+	raw, err = rlp.EncodeToBytes(blockRLP{header, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeBlock(raw); err == nil {
+		t.Fatal("a block of an unknown encoding version should be rejected")
+	}
+}
@@ -0,0 +1,368 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind identifies a type of state event. Kinds are bit flags so a
+// subscription can ask for several of them at once.
+type EventKind uint8
+
+const (
+	EventBlockAdded EventKind = 1 << iota
+	EventBalanceChanged
+	EventReorg
+)
+
+const AllEvents = EventBlockAdded | EventBalanceChanged | EventReorg
+
+const DefaultEventBuffer = 64
+const maxEventBuffer = 4096
+
+// Event is published by the State to its subscribers once a change is
+// persisted. Use a type switch to get the BlockAdded, BalanceChanged or
+// Reorg it holds.
+type Event interface {
+	Kind() EventKind
+}
+
+// BlockAdded is published for every block becoming part of the canonical
+// chain, including the blocks added by a reorg.
+type BlockAdded struct {
+	Hash  Hash
+	Block Block
+}
+
+// BalanceChanged is published for every account whose balance in Asset
+// differs after the canonical head moved to Head.
+type BalanceChanged struct {
+	Asset   string
+	Account common.Address
+	Old     uint
+	New     uint
+	Head    Hash
+}
+
+func (BlockAdded) Kind() EventKind {
+	return EventBlockAdded
+}
+
+func (BalanceChanged) Kind() EventKind {
+	return EventBalanceChanged
+}
+
+func (Reorg) Kind() EventKind {
+	return EventReorg
+}
+
+// OverflowPolicy decides what happens to an event published while the buffer
+// of a subscription is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the event and counts it in Dropped.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock holds the delivery of events until the subscriber catches
+	// up. Blocks are still imported meanwhile, but no event is delivered to
+	// any subscription and the events of every imported block are queued in
+	// memory until then.
+	OverflowBlock
+	// OverflowUnsubscribe closes the subscription, Err then returns
+	// ErrSubscriptionLagged.
+	OverflowUnsubscribe
+)
+
+var ErrSubscriptionLagged = errors.New("subscription lagged behind the published events")
+
+type Subscription struct {
+	hub     *eventHub
+	kinds   EventKind
+	policy  OverflowPolicy
+	events  chan Event
+	done    chan struct{}
+	sendMu  sync.RWMutex
+	once    sync.Once
+	dropped uint64
+	err     error
+}
+
+// Events delivers the events of the subscription in publication order. It is
+// closed once the subscription ends.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Dropped returns how many events were dropped because the buffer was full.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Err returns why the subscription ended on its own, if it did.
+func (sub *Subscription) Err() error {
+	sub.hub.mu.RLock()
+	defer sub.hub.mu.RUnlock()
+
+	return sub.err
+}
+
+func (sub *Subscription) Unsubscribe() {
+	sub.end(nil)
+}
+
+func (sub *Subscription) end(err error) {
+	sub.once.Do(func() {
+		close(sub.done)
+
+		sub.hub.mu.Lock()
+		delete(sub.hub.subs, sub)
+		sub.err = err
+		sub.hub.mu.Unlock()
+
+		// Closing done released a blocked deliver, events is closed once no
+		// delivery is left sending to it.
+		sub.sendMu.Lock()
+		close(sub.events)
+		sub.sendMu.Unlock()
+	})
+}
+
+// deliver sends e without blocking, unless the policy is OverflowBlock, and
+// reports whether the subscription fell behind and must be ended. Nothing is
+// sent to an ended subscription.
+func (sub *Subscription) deliver(e Event) bool {
+	sub.sendMu.RLock()
+	defer sub.sendMu.RUnlock()
+
+	select {
+	case <-sub.done:
+		return false
+	default:
+	}
+
+	if sub.policy == OverflowBlock {
+		select {
+		case sub.events <- e:
+		case <-sub.done:
+		}
+
+		return false
+	}
+
+	select {
+	case sub.events <- e:
+		return false
+	default:
+		atomic.AddUint64(&sub.dropped, 1)
+		return sub.policy == OverflowUnsubscribe
+	}
+}
+
+// eventHub fans published events out to subscriptions. Batches of events are
+// queued and delivered in order by a dispatcher goroutine, so publishers never
+// wait on subscribers.
+type eventHub struct {
+	mu       sync.RWMutex
+	subs     map[*Subscription]bool
+	queueMu  sync.Mutex
+	queued   *sync.Cond
+	queue    [][]Event
+	closed   bool
+	dispatch sync.Once
+}
+
+func newEventHub() *eventHub {
+	h := &eventHub{subs: make(map[*Subscription]bool)}
+	h.queued = sync.NewCond(&h.queueMu)
+
+	return h
+}
+
+func (h *eventHub) subscribe(kinds EventKind, buffer int, policy OverflowPolicy) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	if buffer > maxEventBuffer {
+		buffer = maxEventBuffer
+	}
+
+	sub := &Subscription{
+		hub:    h,
+		kinds:  kinds,
+		policy: policy,
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+
+	return sub
+}
+
+// publish delivers events to the subscriptions at the time of the call. They
+// are copied first so a subscriber held by OverflowBlock doesn't keep h.mu,
+// and with it Subscribe, Unsubscribe and Err, waiting.
+func (h *eventHub) publish(events []Event) {
+	lagging := make([]*Subscription, 0)
+
+	h.mu.RLock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.RUnlock()
+
+	for _, e := range events {
+		for _, sub := range subs {
+			if sub.kinds&e.Kind() == 0 {
+				continue
+			}
+
+			if sub.deliver(e) {
+				lagging = append(lagging, sub)
+			}
+		}
+	}
+
+	for _, sub := range lagging {
+		sub.end(ErrSubscriptionLagged)
+	}
+}
+
+// enqueue queues events to be published after the batches queued before
+// them. It doesn't block, the dispatcher is started on the first batch.
+func (h *eventHub) enqueue(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	h.dispatch.Do(func() {
+		go h.dispatchQueued()
+	})
+
+	h.queueMu.Lock()
+	if !h.closed {
+		h.queue = append(h.queue, events)
+		h.queued.Signal()
+	}
+	h.queueMu.Unlock()
+}
+
+func (h *eventHub) dispatchQueued() {
+	for {
+		h.queueMu.Lock()
+		for len(h.queue) == 0 && !h.closed {
+			h.queued.Wait()
+		}
+
+		if h.closed {
+			h.queueMu.Unlock()
+			return
+		}
+
+		events := h.queue[0]
+		h.queue[0] = nil
+		h.queue = h.queue[1:]
+		h.queueMu.Unlock()
+
+		h.publish(events)
+	}
+}
+
+func (h *eventHub) close() {
+	h.queueMu.Lock()
+	h.closed = true
+	h.queue = nil
+	h.queued.Broadcast()
+	h.queueMu.Unlock()
+
+	h.mu.RLock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+// Subscribe returns a subscription to the events of the given kinds, buffering
+// up to buffer of them. A full buffer is handled according to policy.
+func (s *State) Subscribe(kinds EventKind, buffer int, policy OverflowPolicy) *Subscription {
+	return s.events.subscribe(kinds, buffer, policy)
+}
+
+// publishEvents queues the events of the last change for delivery. Callers
+// hold s.mu so the events of successive changes are queued in order.
+func (s *State) publishEvents() {
+	events := s.queuedEvents
+	s.queuedEvents = nil
+
+	if s.events != nil {
+		s.events.enqueue(events)
+	}
+}
+
+// balanceChanges returns a BalanceChanged event for every one of accounts
+// whose balance in an asset differs between before and after, ordered by
+// asset and account.
+func balanceChanges(before *State, after *State, accounts map[string][]common.Address, head Hash) []Event {
+	assets := make([]string, 0, len(accounts))
+	for asset := range accounts {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	events := make([]Event, 0)
+	for _, asset := range assets {
+		changed := make([]common.Address, 0)
+		for _, account := range accounts[asset] {
+			if before.Balance(asset, account) != after.Balance(asset, account) {
+				changed = append(changed, account)
+			}
+		}
+
+		sort.Slice(changed, func(i, j int) bool {
+			return bytes.Compare(changed[i][:], changed[j][:]) < 0
+		})
+
+		for _, account := range changed {
+			events = append(events, BalanceChanged{asset, account, before.Balance(asset, account), after.Balance(asset, account), head})
+		}
+	}
+
+	return events
+}
+
+// balanceAccounts returns every account holding a balance in before or after,
+// by asset.
+func balanceAccounts(before *State, after *State) map[string][]common.Address {
+	accounts := make(map[string][]common.Address)
+	add := func(asset string, balances map[common.Address]uint, skip map[common.Address]uint) {
+		for account := range balances {
+			if _, ok := skip[account]; !ok {
+				accounts[asset] = append(accounts[asset], account)
+			}
+		}
+	}
+
+	add(NativeAsset, after.Balances, nil)
+	add(NativeAsset, before.Balances, after.Balances)
+
+	for asset, balances := range after.AssetBalances {
+		add(asset, balances, nil)
+	}
+	for asset, balances := range before.AssetBalances {
+		add(asset, balances, after.AssetBalances[asset])
+	}
+
+	return accounts
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	all := s.Subscribe(AllEvents, 0, OverflowDrop)
+	blocks := s.Subscribe(EventBlockAdded, 1, OverflowDrop)
+	lagging := s.Subscribe(EventBlockAdded, 1, OverflowUnsubscribe)
+	balances := s.Subscribe(EventBalanceChanged, 0, OverflowDrop)
+
+	b := mineTestBlock(t, s)
+	hash := s.LatestBlockHash()
+
+	added, ok := (<-all.Events()).(BlockAdded)
+	if !ok || added.Hash != hash || added.Block.Header.Number != b.Header.Number {
+		t.Fatalf("first event should be block '%s' being added, got %+v", hash.Hex(), added)
+	}
+
+	changed, ok := (<-all.Events()).(BalanceChanged)
+	if !ok || changed.Account != b.Header.Miner || changed.Old != 0 || changed.New != DefaultBlockReward || changed.Head != hash {
+		t.Fatalf("miner balance should change from 0 to %d, got %+v", DefaultBlockReward, changed)
+	}
+
+	if len(all.Events()) != 0 {
+		t.Fatalf("no other event should be published, got %d", len(all.Events()))
+	}
+
+	mineTestBlock(t, s)
+
+	// Events are delivered in order, once the balance of the second block
+	// changed its block was delivered to every subscription.
+	<-balances.Events()
+	<-balances.Events()
+
+	if blocks.Dropped() != 1 || len(blocks.Events()) != 1 {
+		t.Fatalf("the second block should be dropped by a full subscription, %d were dropped", blocks.Dropped())
+	}
+
+	<-lagging.Events()
+	if _, open := <-lagging.Events(); open || lagging.Err() != ErrSubscriptionLagged {
+		t.Fatalf("a lagging subscription should be closed, got %v", lagging.Err())
+	}
+
+	all.Unsubscribe()
+	if _, open := <-all.Events(); !open {
+		t.Fatal("events published before unsubscribing should still be delivered")
+	}
+}
+
+func TestSubscribe_OverflowBlock(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	sub := s.Subscribe(EventBlockAdded, 1, OverflowBlock)
+
+	// Blocks are imported while the subscriber doesn't read its full buffer.
+	for i := 0; i < 3; i++ {
+		mineTestBlock(t, s)
+	}
+	head := s.LatestBlockHash()
+
+	var last Hash
+	for number := uint64(0); number < 3; number++ {
+		added := (<-sub.Events()).(BlockAdded)
+		if added.Block.Header.Number != number {
+			t.Fatalf("block %d should be delivered next, got %d", number, added.Block.Header.Number)
+		}
+		last = added.Hash
+	}
+
+	if head != last || sub.Dropped() != 0 {
+		t.Fatalf("every block up to '%s' should be delivered, got '%s' and %d dropped", head.Hex(), last.Hex(), sub.Dropped())
+	}
+}
+
+func TestSubscribe_OverflowBlockDoesNotHoldHub(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	stalled := s.Subscribe(EventBlockAdded, 1, OverflowBlock)
+
+	// The dispatcher is left waiting on the stalled subscriber's full buffer.
+	for i := 0; i < 3; i++ {
+		mineTestBlock(t, s)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		other := s.Subscribe(EventBlockAdded, 1, OverflowDrop)
+		other.Unsubscribe()
+
+		if err := stalled.Err(); err != nil {
+			t.Errorf("stalled subscription should not have ended, got %v", err)
+		}
+		stalled.Unsubscribe()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe, Err and Unsubscribe should not wait on a stalled OverflowBlock subscriber")
+	}
+
+	if stalled.Err() != nil {
+		t.Fatalf("an unsubscribed subscription should have no error, got %v", stalled.Err())
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rlp"
+	"io"
+)
+
+// An export file starts with exportMagic and the RLP encoded chain id,
+// followed by the canonical encoding of every exported block in chain order.
+var exportMagic = []byte("TUBEXP01")
+
+// ExportBlocks writes the canonical blocks numbered from..to to w and
+// returns how many were written.
+func (s *State) ExportBlocks(w io.Writer, from uint64, to uint64) (int, error) {
+	if !s.hasGenesisBlock {
+		return 0, fmt.Errorf("there are no blocks to export yet")
+	}
+
+	if to > s.latestBlock.Header.Number {
+		to = s.latestBlock.Header.Number
+	}
+
+	if from > to {
+		return 0, fmt.Errorf("no blocks between %d and %d, the latest block is %d", from, to, s.latestBlock.Header.Number)
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(exportMagic); err != nil {
+		return 0, err
+	}
+
+	if err := rlp.Encode(bw, s.genesis.ChainId); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for number := from; number <= to; number++ {
+		b, err := s.GetBlockByNumber(number)
+		if err != nil {
+			return count, err
+		}
+
+		if err := rlp.Encode(bw, b.toRLP()); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, bw.Flush()
+}
+
+// ImportBlocks adds every block of an export read from r, validating them
+// like any other block, and returns how many were read. Blocks already known
+// are skipped.
+func (s *State) ImportBlocks(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, exportMagic) {
+		return 0, fmt.Errorf("not a block export file")
+	}
+
+	stream := rlp.NewStream(br, 0)
+
+	var chainId string
+	if err := stream.Decode(&chainId); err != nil {
+		return 0, fmt.Errorf("unable to read the chain id of the export. %s", err.Error())
+	}
+
+	if chainId != s.genesis.ChainId {
+		return 0, fmt.Errorf("blocks were exported from chain '%s', not '%s'", chainId, s.genesis.ChainId)
+	}
+
+	count := 0
+	for {
+		var r blockRLP
+		err := stream.Decode(&r)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("unable to decode block %d of the export. %s", count, err.Error())
+		}
+
+		b, err := r.toBlock()
+		if err != nil {
+			return count, err
+		}
+
+		if _, err := s.AddBlock(b); err != nil {
+			return count, fmt.Errorf("block %d is invalid. %s", b.Header.Number, err.Error())
+		}
+		count++
+	}
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"bytes"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestChainState(t *testing.T, chainId string) (*State, string) {
+	return newFundedTestChainState(t, chainId, map[common.Address]uint{})
+}
+
+func newFundedTestChainState(t *testing.T, chainId string, balances map[common.Address]uint) (*State, string) {
+	return newGenesisTestChainState(t, Genesis{GenesisTime: time.Now().UTC().Format(time.RFC3339Nano), ChainId: chainId, Balances: balances, Difficulty: 1})
+}
+
+func newGenesisTestChainState(t *testing.T, genesis Genesis) (*State, string) {
+	dataDir, err := ioutil.TempDir(os.TempDir(), ".tub_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitDataDirWithGenesis(dataDir, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, dataDir
+}
+
+// mineTestBlock mines an empty block on top of the latest block of s.
+func mineTestBlock(t *testing.T, s *State) Block {
+	return mineTestTxs(t, s, nil)
+}
+
+// mineTestTxs mines a block of txs on top of the latest block of s.
+func mineTestTxs(t *testing.T, s *State, txs []SignedTx) Block {
+	miner := NewAccount("0x9")
+	parent := s.LatestBlockHash()
+
+	difficulty, err := s.NextDifficulty(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockTime, err := s.NextBlockTime(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateRoot, err := s.PendingStateRoot(txs, miner, blockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for nonce := uint32(0); ; nonce++ {
+		b, err := NewBlock(parent, s.NextBlockNumber(), nonce, difficulty, blockTime, miner, stateRoot, txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, difficulty) {
+			if _, err := s.AddBlock(b); err != nil {
+				t.Fatal(err)
+			}
+
+			return b
+		}
+	}
+}
+
+func TestExportImportBlocks(t *testing.T) {
+	source, sourceDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(sourceDir)
+	defer source.Close()
+
+	for i := 0; i < 3; i++ {
+		mineTestBlock(t, source)
+	}
+
+	var export bytes.Buffer
+	count, err := source.ExportBlocks(&export, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 3 {
+		t.Fatalf("3 blocks should be exported, got %d", count)
+	}
+
+	target, targetDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(targetDir)
+	defer target.Close()
+
+	if _, err := target.ImportBlocks(bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if target.LatestBlockHash() != source.LatestBlockHash() {
+		t.Fatal("imported chain should have the head of the exported one")
+	}
+
+	if _, err := target.ImportBlocks(bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatalf("importing known blocks again should succeed. %s", err)
+	}
+
+	other, otherDir := newTestChainState(t, "other")
+	defer os.RemoveAll(otherDir)
+	defer other.Close()
+
+	if _, err := other.ImportBlocks(bytes.NewReader(export.Bytes())); err == nil {
+		t.Fatal("blocks of another chain should be rejected")
+	}
+
+	tampered, tamperedDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(tamperedDir)
+	defer tampered.Close()
+
+	content := append([]byte(nil), export.Bytes()...)
+	content[len(content)-1] ^= 0xff
+	count, err = tampered.ImportBlocks(bytes.NewReader(content))
+	if err == nil {
+		t.Fatal("a tampered block should be rejected")
+	}
+
+	if count != 2 {
+		t.Fatalf("the blocks before the tampered one should be imported, got %d", count)
+	}
+}
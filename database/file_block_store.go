@@ -0,0 +1,216 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// fileBlockStore keeps blocks as checksummed records in block.db with a block.idx
+// index next to it. The hash of the canonical head is kept in block.head.
+type fileBlockStore struct {
+	dbFile   *os.File
+	index    *blockIndex
+	headPath string
+}
+
+func openFileBlockStore(dataDir string) (*fileBlockStore, error) {
+	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := openBlockIndex(getBlocksIndexFilePath(dataDir))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	bs := &fileBlockStore{f, index, getBlocksHeadFilePath(dataDir)}
+	if err := bs.recover(); err != nil {
+		bs.Close()
+		return nil, err
+	}
+
+	if err := bs.loadHead(); err != nil {
+		bs.Close()
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+// loadHead restores the canonical chain. Data dirs written before forks were
+// tracked have no block.head, their canonical head is the highest block.
+func (bs *fileBlockStore) loadHead() error {
+	var head Hash
+
+	content, err := ioutil.ReadFile(bs.headPath)
+	if err == nil {
+		err = head.UnmarshalText(bytes.TrimSpace(content))
+	}
+
+	if _, ok := bs.index.locationByHash(head); err != nil || !ok {
+		highest, found := bs.index.highestBlock()
+		if !found {
+			return nil
+		}
+		head = highest
+	}
+
+	return bs.index.setHead(head)
+}
+
+// recover indexes the records written after the last indexed block and
+// truncates block.db to the last valid block if the node died while a block
+// was being written. A corrupted record followed by others is not dropped,
+// the open fails and 'tub db repair' has to be run instead.
+func (bs *fileBlockStore) recover() error {
+	stat, err := bs.dbFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	from := bs.index.end()
+	if from > stat.Size() {
+		fmt.Println("Block index points past the end of block.db, rebuilding it")
+
+		if err := bs.index.reset(); err != nil {
+			return err
+		}
+		from = 0
+	}
+
+	validEnd, err := scanBlockRecords(bs.dbFile, from, func(blockFs BlockFs, offset int64, length uint32) error {
+		return bs.index.add(blockFs.Key, blockFs.Value.Header.Parent, blockFs.Value.Header.Number, offset, length)
+	})
+	if err != nil {
+		return err
+	}
+
+	if validEnd == stat.Size() {
+		return nil
+	}
+
+	tail := make([]byte, stat.Size()-validEnd)
+	if _, err := bs.dbFile.ReadAt(tail, validEnd); err != nil {
+		return err
+	}
+
+	if bytes.IndexByte(tail, '\n') != -1 {
+		return fmt.Errorf("block.db is corrupted past offset %d, run 'tub db repair' to drop the corrupted blocks", validEnd)
+	}
+
+	fmt.Printf("WARNING: block.db has a torn last record, dropping its last %d bytes\n", stat.Size()-validEnd)
+
+	if err := bs.dbFile.Truncate(validEnd); err != nil {
+		return err
+	}
+
+	return bs.dbFile.Sync()
+}
+
+func fileBlockStoreHasBlocks(dataDir string) (bool, error) {
+	stat, err := os.Stat(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return stat.Size() > 0, nil
+}
+
+func (bs *fileBlockStore) Append(blockFs BlockFs) error {
+	record, err := encodeBlockRecord(blockFs)
+	if err != nil {
+		return err
+	}
+
+	stat, err := bs.dbFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := bs.dbFile.Write(record); err != nil {
+		return err
+	}
+
+	if err := bs.dbFile.Sync(); err != nil {
+		return err
+	}
+
+	return bs.index.add(blockFs.Key, blockFs.Value.Header.Parent, blockFs.Value.Header.Number, stat.Size(), uint32(len(record)))
+}
+
+func (bs *fileBlockStore) SetHead(head Hash) error {
+	if err := bs.index.setHead(head); err != nil {
+		return err
+	}
+
+	return writeFileAtomically(bs.headPath, []byte(head.Hex()))
+}
+
+func (bs *fileBlockStore) Iterate(fn func(blockFs BlockFs) error) error {
+	f, err := os.Open(bs.dbFile.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		record, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(record) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		blockFs, err := decodeBlockRecord(record)
+		if err != nil {
+			return fmt.Errorf("block.db is corrupted, run 'tub db repair'. %s", err.Error())
+		}
+
+		if err := fn(blockFs); err != nil {
+			return err
+		}
+	}
+}
+
+func (bs *fileBlockStore) GetByHash(hash Hash) (Block, error) {
+	loc, ok := bs.index.locationByHash(hash)
+	if !ok {
+		return Block{}, fmt.Errorf("%w: hash '%s'", ErrBlockNotFound, hash.Hex())
+	}
+
+	blockFs, err := readBlockAt(bs.dbFile, loc)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return blockFs.Value, nil
+}
+
+func (bs *fileBlockStore) GetByHeight(number uint64) (Block, error) {
+	hash, ok := bs.index.hashByHeight(number)
+	if !ok {
+		return Block{}, fmt.Errorf("%w: number '%d'", ErrBlockNotFound, number)
+	}
+
+	return bs.GetByHash(hash)
+}
+
+func (bs *fileBlockStore) Close() error {
+	if err := bs.index.close(); err != nil {
+		return err
+	}
+
+	return bs.dbFile.Close()
+}
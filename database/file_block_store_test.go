@@ -0,0 +1,230 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func setUpTestDataDir(t *testing.T) string {
+	dataDir, err := ioutil.TempDir(os.TempDir(), ".tub_db_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitDataDir(dataDir, []byte(genesisJson)); err != nil {
+		t.Fatal(err)
+	}
+
+	return dataDir
+}
+
+func createTestBlocks(t *testing.T, count int) []BlockFs {
+	blocks := make([]BlockFs, 0, count)
+	parent := Hash{}
+	for i := 0; i < count; i++ {
+		b, err := NewBlock(parent, uint64(i), uint32(i), DefaultDifficulty, uint64(i), NewAccount(""), Hash{}, []SignedTx{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks = append(blocks, BlockFs{hash, b})
+		parent = hash
+	}
+
+	return blocks
+}
+
+func appendToBlocksDb(t *testing.T, dataDir string, content []byte) {
+	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileBlockStore_ReadsLegacyRecords(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	blocks := createTestBlocks(t, 3)
+	for _, blockFs := range blocks {
+		blockFsJson, err := json.Marshal(blockFs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		appendToBlocksDb(t, dataDir, append(blockFsJson, '\n'))
+	}
+
+	store, err := openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, blockFs := range blocks {
+		b, err := store.GetByHeight(blockFs.Value.Header.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.Header != blockFs.Value.Header {
+			t.Fatalf("legacy block %d was not indexed", blockFs.Value.Header.Number)
+		}
+	}
+}
+
+func TestFileBlockStore_RebuildsCorruptedIndex(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	blocks := createTestBlocks(t, 3)
+
+	store, err := openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blockFs := range blocks {
+		if err := store.Append(blockFs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.SetHead(blocks[len(blocks)-1].Key); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if err := os.Truncate(getBlocksIndexFilePath(dataDir), int64(len(indexMagic)+indexRecordSize+7)); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	latest, ok := store.index.latestNumber()
+	if !ok || latest != uint64(len(blocks)-1) {
+		t.Fatalf("rebuilt index should end at block %d, not %d", len(blocks)-1, latest)
+	}
+}
+
+func TestFileBlockStore_TruncatesTornTail(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	blocks := createTestBlocks(t, 3)
+
+	store, err := openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blockFs := range blocks[:2] {
+		if err := store.Append(blockFs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	stat, err := os.Stat(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := encodeBlockRecord(blocks[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendToBlocksDb(t, dataDir, record[:len(record)/2])
+
+	store, err = openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	truncated, err := os.Stat(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if truncated.Size() != stat.Size() {
+		t.Fatalf("torn record should have been truncated, block.db is %d bytes instead of %d", truncated.Size(), stat.Size())
+	}
+
+	if err := store.Append(blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	iterated := 0
+	err = store.Iterate(func(blockFs BlockFs) error {
+		iterated++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if iterated != len(blocks) {
+		t.Fatalf("expected %d blocks after re-appending the torn one, got %d", len(blocks), iterated)
+	}
+}
+
+func TestFileBlockStore_RefusesCorruptedRecord(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	store, err := openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blockFs := range createTestBlocks(t, 3) {
+		if err := store.Append(blockFs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	content, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupts the content of the second record, past its checksum and the
+	// space following it. The index is dropped so that the record is scanned.
+	second := bytes.IndexByte(content, '\n') + 1
+	content[second+checksumLength+2] ^= 1
+	if err := ioutil.WriteFile(getBlocksDBFilePath(dataDir), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(getBlocksIndexFilePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openFileBlockStore(dataDir); err == nil {
+		t.Fatal("block.db with a corrupted record followed by valid ones should not open")
+	}
+
+	stat, err := os.Stat(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size() != int64(len(content)) {
+		t.Fatalf("block.db should be left untouched for 'tub db repair', it is %d bytes instead of %d", stat.Size(), len(content))
+	}
+}
@@ -0,0 +1,324 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"time"
+)
+
+// Reorg describes a switch of the canonical chain to a heavier branch.
+// Orphaned holds the transactions of the abandoned blocks that the new
+// branch did not include.
+type Reorg struct {
+	OldHead        Hash
+	NewHead        Hash
+	CommonAncestor Hash
+	Dropped        []Hash
+	Added          []Hash
+	Orphaned       []SignedTx
+}
+
+// blockTreeNode tracks a known block of any branch. Total work is the work of
+// its whole chain, from the genesis block.
+type blockTreeNode struct {
+	parent    Hash
+	number    uint64
+	totalWork *big.Int
+}
+
+// blockWork returns the proof-of-work a block contributes to its chain, the
+// number of attempts its difficulty takes on average.
+func blockWork(header BlockHeader) *big.Int {
+	return new(big.Int).SetUint64(header.Difficulty)
+}
+
+func (s *State) trackBlock(hash Hash, header BlockHeader) *blockTreeNode {
+	totalWork := new(big.Int)
+	if parent, ok := s.tree[header.Parent]; ok {
+		totalWork.Set(parent.totalWork)
+	}
+
+	node := &blockTreeNode{header.Parent, header.Number, totalWork.Add(totalWork, blockWork(header))}
+	s.tree[hash] = node
+
+	return node
+}
+
+// trackSideBlocks adds the stored blocks of side branches to the block tree,
+// once the canonical chain is replayed. Branches forking before the oldest
+// tracked block can't be compared to the canonical one and are left out.
+func (s *State) trackSideBlocks() error {
+	return s.store.Iterate(func(blockFs BlockFs) error {
+		if _, isKnown := s.tree[blockFs.Key]; isKnown {
+			return nil
+		}
+
+		if _, isParentKnown := s.tree[blockFs.Value.Header.Parent]; isParentKnown {
+			s.trackBlock(blockFs.Key, blockFs.Value.Header)
+		}
+
+		return nil
+	})
+}
+
+// canonicalWork returns the total work of the canonical chain up to block
+// number, for snapshots taken before it was kept in them.
+func canonicalWork(store BlockStore, number uint64) (*big.Int, error) {
+	totalWork := new(big.Int)
+	for n := uint64(0); n <= number; n++ {
+		b, err := store.GetByHeight(n)
+		if err != nil {
+			return nil, err
+		}
+
+		totalWork.Add(totalWork, blockWork(b.Header))
+	}
+
+	return totalWork, nil
+}
+
+// addSideBlock stores a valid looking block that does not extend the current
+// head and reorganizes the chain if its branch now has more work.
+func (s *State) addSideBlock(hash Hash, b Block) (Hash, error) {
+	if err := s.validateDifficulty(b); err != nil {
+		return Hash{}, err
+	}
+
+	if err := s.validateBlockTime(b, uint64(time.Now().Unix())); err != nil {
+		return Hash{}, err
+	}
+
+	if !IsBlockHashValid(hash, b.Header.Difficulty) {
+		return Hash{}, fmt.Errorf("invalid block hash %x", hash)
+	}
+
+	if err := validateTxRoot(b); err != nil {
+		return Hash{}, err
+	}
+
+	if err := s.store.Append(BlockFs{hash, b}); err != nil {
+		return Hash{}, err
+	}
+
+	node := s.trackBlock(hash, b.Header)
+	head := s.tree[s.latestBlockHash]
+
+	if head != nil && node.totalWork.Cmp(head.totalWork) <= 0 {
+		fmt.Printf("Stored block '%s' of a side branch at height %d\n", hash.Hex(), b.Header.Number)
+		return hash, nil
+	}
+
+	if err := s.reorg(hash); err != nil {
+		s.forgetBranch(hash)
+		return Hash{}, err
+	}
+
+	return hash, nil
+}
+
+// reorg rolls the state back to the common ancestor of the current head and
+// newHead and forward along the branch of newHead.
+func (s *State) reorg(newHead Hash) error {
+	oldHead := s.latestBlockHash
+	ancestor, dropped, added, err := s.forkPoint(oldHead, newHead)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reorganizing chain from '%s' to '%s', %d blocks dropped and %d added\n", oldHead.Hex(), newHead.Hex(), len(dropped), len(added))
+
+	pendingState, err := s.stateAt(ancestor)
+	if err != nil {
+		return err
+	}
+
+	includedTxs := make(map[Hash]bool)
+	addedEvents := make([]Event, 0, len(added))
+	for _, hash := range added {
+		b, err := s.store.GetByHash(hash)
+		if err != nil {
+			return err
+		}
+
+		if err := applyBlock(b, pendingState); err != nil {
+			return fmt.Errorf("block '%s' of the heavier branch is invalid. %s", hash.Hex(), err.Error())
+		}
+
+		pendingState.setHead(hash, b)
+		addedEvents = append(addedEvents, BlockAdded{hash, b})
+
+		for _, tx := range b.Txs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				return err
+			}
+			includedTxs[txHash] = true
+		}
+	}
+
+	orphaned := make([]SignedTx, 0)
+	for _, hash := range dropped {
+		b, err := s.store.GetByHash(hash)
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range b.Txs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				return err
+			}
+
+			if !includedTxs[txHash] {
+				orphaned = append(orphaned, tx)
+			}
+		}
+	}
+
+	if err := s.store.SetHead(newHead); err != nil {
+		return err
+	}
+
+	s.queuedEvents = append(s.queuedEvents, Reorg{oldHead, newHead, ancestor, dropped, added, orphaned})
+	s.queuedEvents = append(s.queuedEvents, addedEvents...)
+	s.queuedEvents = append(s.queuedEvents, balanceChanges(s, pendingState, balanceAccounts(s, pendingState), newHead)...)
+
+	s.commit(pendingState)
+
+	if s.accountIndex != nil {
+		if err := s.reindexAccounts(dropped, added); err != nil {
+			fmt.Printf("WARNING: unable to reindex the txs of the reorganized blocks. %s\n", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *State) reindexAccounts(dropped []Hash, added []Hash) error {
+	for _, hash := range dropped {
+		if err := s.accountIndex.drop(hash); err != nil {
+			return err
+		}
+	}
+
+	for _, hash := range added {
+		b, err := s.store.GetByHash(hash)
+		if err != nil {
+			return err
+		}
+
+		if err := s.accountIndex.add(hash, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forkPoint returns the common ancestor of two known blocks and the blocks
+// of each branch after it, in chain order.
+func (s *State) forkPoint(oldHead Hash, newHead Hash) (Hash, []Hash, []Hash, error) {
+	dropped := make([]Hash, 0)
+	added := make([]Hash, 0)
+
+	oldHash, newHash := oldHead, newHead
+	for oldHash != newHash {
+		oldNode, newNode := s.tree[oldHash], s.tree[newHash]
+		if oldNode == nil && newNode == nil {
+			return Hash{}, nil, nil, fmt.Errorf("blocks '%s' and '%s' have no known common ancestor", oldHead.Hex(), newHead.Hex())
+		}
+
+		if oldNode != nil && (newNode == nil || oldNode.number >= newNode.number) {
+			dropped = append([]Hash{oldHash}, dropped...)
+			oldHash = oldNode.parent
+		} else {
+			added = append([]Hash{newHash}, added...)
+			newHash = newNode.parent
+		}
+	}
+
+	return oldHash, dropped, added, nil
+}
+
+// forgetBranch removes hash and every known descendant from the block tree
+// so an invalid branch is never selected again.
+func (s *State) forgetBranch(hash Hash) {
+	delete(s.tree, hash)
+
+	for child, node := range s.tree {
+		if node.parent == hash {
+			s.forgetBranch(child)
+		}
+	}
+}
+
+// stateAt rebuilds the balances and nonces right after the canonical block
+// ancestor, from the closest snapshot or from the genesis.
+func (s *State) stateAt(ancestor Hash) (*State, error) {
+	genesis, err := loadGenesis(getGenesisJsonFilePath(s.dataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &State{Balances: genesis.copyBalances(), AssetBalances: genesis.copyAssetBalances(), Multisigs: make(map[common.Address]MultisigPolicy), Account2Nonce: make(map[common.Address]uint), store: s.store, dataDir: s.dataDir, genesis: genesis}
+
+	if ancestor.IsEmpty() {
+		return c, nil
+	}
+
+	node, ok := s.tree[ancestor]
+	if !ok {
+		return nil, fmt.Errorf("fork point '%s' is unknown", ancestor.Hex())
+	}
+
+	from := uint64(0)
+	if snapshot, ok := loadLatestSnapshot(s.dataDir, s.store, node.number); ok {
+		c.Balances = snapshot.Balances
+		if snapshot.AssetBalances != nil {
+			c.AssetBalances = snapshot.AssetBalances
+		}
+		c.Multisigs = snapshot.Multisigs
+		c.Account2Nonce = snapshot.Account2Nonce
+		c.latestBlock = snapshot.LatestBlock
+		c.latestBlockHash = snapshot.LatestBlockHash
+		c.hasGenesisBlock = true
+		from = snapshot.LatestBlock.Header.Number + 1
+	}
+
+	if err := c.replayCanonicalBlocks(from, node.number); err != nil {
+		return nil, err
+	}
+
+	if c.latestBlockHash != ancestor {
+		return nil, fmt.Errorf("fork point '%s' is not part of the canonical chain", ancestor.Hex())
+	}
+
+	return c, nil
+}
+
+// replayCanonicalBlocks applies the canonical blocks numbered from..to,
+// stopping early at the canonical head.
+func (s *State) replayCanonicalBlocks(from uint64, to uint64) error {
+	for number := from; number <= to; number++ {
+		b, err := s.store.GetByHeight(number)
+		if errors.Is(err, ErrBlockNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			return err
+		}
+
+		if err := s.replayBlock(BlockFs{hash, b}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
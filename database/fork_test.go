@@ -0,0 +1,126 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// mineTestSideBlock mines an empty block of miner on top of parent and adds
+// it to s. parentState is the state after parent, the block is applied to it.
+func mineTestSideBlock(t *testing.T, s *State, parentState *State, parent Hash, number uint64, miner common.Address) Hash {
+	difficulty, err := s.NextDifficulty(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockTime, err := s.NextBlockTime(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyBlockTxs(number, blockTime, nil, miner, parentState); err != nil {
+		t.Fatal(err)
+	}
+
+	for nonce := uint32(0); ; nonce++ {
+		b, err := NewBlock(parent, number, nonce, difficulty, blockTime, miner, parentState.StateRoot(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, difficulty) {
+			if _, err := s.AddBlock(b); err != nil {
+				t.Fatal(err)
+			}
+
+			return hash
+		}
+	}
+}
+
+func TestForkPoint(t *testing.T) {
+	s := &State{tree: make(map[Hash]*blockTreeNode)}
+
+	// genesis <- a1 <- a2 <- a3
+	//         \- b1 <- b2
+	genesis, a1, a2, a3, b1, b2 := Hash{1}, Hash{2}, Hash{3}, Hash{4}, Hash{5}, Hash{6}
+	s.tree[genesis] = &blockTreeNode{Hash{}, 0, big.NewInt(1)}
+	s.tree[a1] = &blockTreeNode{genesis, 1, big.NewInt(2)}
+	s.tree[a2] = &blockTreeNode{a1, 2, big.NewInt(3)}
+	s.tree[a3] = &blockTreeNode{a2, 3, big.NewInt(4)}
+	s.tree[b1] = &blockTreeNode{genesis, 1, big.NewInt(2)}
+	s.tree[b2] = &blockTreeNode{b1, 2, big.NewInt(3)}
+
+	ancestor, dropped, added, err := s.forkPoint(b2, a3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ancestor != genesis {
+		t.Fatalf("common ancestor should be the genesis, got '%s'", ancestor.Hex())
+	}
+
+	if len(dropped) != 2 || dropped[0] != b1 || dropped[1] != b2 {
+		t.Fatalf("dropped blocks should be b1, b2 in chain order, got %v", dropped)
+	}
+
+	if len(added) != 3 || added[0] != a1 || added[1] != a2 || added[2] != a3 {
+		t.Fatalf("added blocks should be a1, a2, a3 in chain order, got %v", added)
+	}
+
+	s.forgetBranch(b1)
+	if _, ok := s.tree[b2]; ok {
+		t.Fatal("forgetting a branch should forget its descendants")
+	}
+
+	if _, _, _, err := s.forkPoint(a3, b2); err == nil {
+		t.Fatal("blocks without a known common ancestor should fail")
+	}
+}
+
+func TestNewStateFromDisk_TracksSideBranches(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+
+	mineTestBlock(t, s)
+	genesis := s.LatestBlockHash()
+	if _, err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlock(t, s)
+
+	sideState, err := s.stateAt(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := mineTestSideBlock(t, s, sideState, genesis, 1, NewAccount("0x8"))
+	s.Close()
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	work, err := canonicalWork(s.store, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, head := s.tree[side], s.tree[s.LatestBlockHash()]
+	if node == nil || head.totalWork.Cmp(work) != 0 || node.totalWork.Cmp(work) != 0 {
+		t.Fatal("stored side blocks should be tracked again with the work of their whole chain")
+	}
+
+	newHead := mineTestSideBlock(t, s, sideState, side, 2, NewAccount("0x8"))
+	if s.LatestBlockHash() != newHead {
+		t.Fatalf("side branch with more work should become canonical, head is '%s'", s.LatestBlockHash().Hex())
+	}
+}
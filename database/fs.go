@@ -18,6 +18,30 @@ func getBlocksDBFilePath(dataDir string) string {
 	return filepath.Join(getDatabaseDirPath(dataDir), "block.db")
 }
 
+func getBlocksIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "block.idx")
+}
+
+func getAccountIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "account.idx")
+}
+
+func getBlocksHeadFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "block.head")
+}
+
+func getBlocksLevelDbDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "blocks.ldb")
+}
+
+func getSchemaVersionFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "VERSION")
+}
+
+func getSnapshotsDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "snapshots")
+}
+
 func fileExist(filepath string) bool {
 	_, err := os.Stat(filepath)
 	if err != nil && os.IsNotExist(err) {
@@ -43,7 +67,7 @@ func InitDataDir(dataDir string, genesis []byte) error {
 		return err
 	}
 
-	return nil
+	return writeDataDirVersion(dataDir, SchemaVersion)
 }
 
 func writeEmptyBlocksDbToDisk(path string) error {
@@ -2,8 +2,11 @@ package database
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"io/ioutil"
+	"reflect"
+	"time"
 )
 
 var genesisJson = `
@@ -20,6 +23,106 @@ type Genesis struct {
 	GenesisTime string           `json:"genesis_time"`
 	ChainId     string           `json:"chain_id"`
 	Balances    map[common.Address]uint `json:"balances"`
+	BlockReward uint `json:"block_reward,omitempty"`
+	HalvingInterval uint64 `json:"halving_interval,omitempty"`
+	MaxSupply uint `json:"max_supply,omitempty"`
+	Difficulty  uint64 `json:"difficulty,omitempty"`
+	TargetBlockTime uint64 `json:"target_block_time,omitempty"`
+	MaxBlockBytes uint64 `json:"max_block_bytes,omitempty"`
+	MaxBlockTxs uint64 `json:"max_block_txs,omitempty"`
+	MaxReasonLength uint64 `json:"max_reason_length,omitempty"`
+	Assets []GenesisAsset `json:"assets,omitempty"`
+}
+
+// NewGenesis creates the genesis of a new chain starting now. Chain params
+// left to 0 fall back to their defaults.
+func NewGenesis(chainId string, balances map[common.Address]uint, blockReward uint, halvingInterval uint64, maxSupply uint, difficulty uint64, targetBlockTime uint64, limits BlockLimits) Genesis {
+	return Genesis{
+		GenesisTime:     time.Now().UTC().Format(time.RFC3339Nano),
+		ChainId:         chainId,
+		Balances:        balances,
+		BlockReward:     blockReward,
+		HalvingInterval: halvingInterval,
+		MaxSupply:       maxSupply,
+		Difficulty:      difficulty,
+		TargetBlockTime: targetBlockTime,
+		MaxBlockBytes:   limits.MaxBlockBytes,
+		MaxBlockTxs:     limits.MaxBlockTxs,
+		MaxReasonLength: limits.MaxReasonLength,
+	}
+}
+
+func (g Genesis) Validate() error {
+	if g.ChainId == "" {
+		return fmt.Errorf("genesis chain id is required")
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, g.GenesisTime); err != nil {
+		return fmt.Errorf("genesis time '%s' is invalid. %s", g.GenesisTime, err.Error())
+	}
+
+	if g.MaxBlockBytes > 0 {
+		headerSize, err := maxHeaderBlockSize()
+		if err != nil {
+			return err
+		}
+
+		if g.MaxBlockBytes <= headerSize {
+			return fmt.Errorf("max block bytes %d don't leave room for any TX, a header alone takes up to %d bytes", g.MaxBlockBytes, headerSize)
+		}
+	}
+
+	if err := g.validateAssets(); err != nil {
+		return err
+	}
+
+	supply := uint(0)
+	for _, balance := range g.Balances {
+		supply += balance
+	}
+
+	if g.MaxSupply > 0 && supply > g.MaxSupply {
+		return fmt.Errorf("genesis balances of %d TUB exceed the max supply of %d TUB", supply, g.MaxSupply)
+	}
+
+	return nil
+}
+
+// InitialReward is the TUB minted to the miner of every block until the
+// first halving.
+func (g Genesis) InitialReward() uint {
+	if g.BlockReward == 0 {
+		return DefaultBlockReward
+	}
+
+	return g.BlockReward
+}
+
+// InitialDifficulty is the difficulty of the first block, retargeted from
+// there on to keep blocks TargetBlockTime seconds apart.
+func (g Genesis) InitialDifficulty() uint64 {
+	if g.Difficulty == 0 {
+		return DefaultDifficulty
+	}
+
+	return g.Difficulty
+}
+
+func (g Genesis) BlockTime() uint64 {
+	if g.TargetBlockTime == 0 {
+		return DefaultTargetBlockTime
+	}
+
+	return g.TargetBlockTime
+}
+
+func (g Genesis) copyBalances() map[common.Address]uint {
+	balances := make(map[common.Address]uint)
+	for acc, balance := range g.Balances {
+		balances[acc] = balance
+	}
+
+	return balances
 }
 
 func loadGenesis(path string) (Genesis, error) {
@@ -36,6 +139,49 @@ func loadGenesis(path string) (Genesis, error) {
 
 }
 
+// LoadGenesisFile reads and validates a genesis file, e.g. one created with
+// 'tub genesis init'.
+func LoadGenesisFile(path string) (Genesis, error) {
+	genesis, err := loadGenesis(path)
+	if err != nil {
+		return Genesis{}, err
+	}
+
+	if err := genesis.Validate(); err != nil {
+		return Genesis{}, err
+	}
+
+	return genesis, nil
+}
+
 func writeGenesisToDisk(path string, genesis []byte) error {
 	return ioutil.WriteFile(path, genesis, 0644)
 }
+
+// InitDataDirWithGenesis initializes dataDir with genesis, or checks that an
+// already initialized dataDir belongs to the chain of genesis.
+func InitDataDirWithGenesis(dataDir string, genesis Genesis) error {
+	if err := genesis.Validate(); err != nil {
+		return err
+	}
+
+	if fileExist(getGenesisJsonFilePath(dataDir)) {
+		existing, err := loadGenesis(getGenesisJsonFilePath(dataDir))
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(existing, genesis) {
+			return fmt.Errorf("data dir '%s' is already initialized with the genesis of chain '%s'", dataDir, existing.ChainId)
+		}
+
+		return nil
+	}
+
+	genesisJson, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return InitDataDir(dataDir, genesisJson)
+}
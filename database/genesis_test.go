@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestInitDataDirWithGenesis(t *testing.T) {
+	dataDir, err := ioutil.TempDir(os.TempDir(), ".tub_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	acc := NewAccount("0x01")
+	genesis := NewGenesis("bar-1", map[common.Address]uint{acc: 500}, 7, 0, 0, 0, 0, BlockLimits{})
+
+	if err := InitDataDirWithGenesis(dataDir, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitDataDirWithGenesis(dataDir, genesis); err != nil {
+		t.Fatalf("initializing again with the same genesis should succeed. %s", err)
+	}
+
+	other := NewGenesis("bar-2", map[common.Address]uint{acc: 500}, 7, 0, 0, 0, 0, BlockLimits{})
+	if err := InitDataDirWithGenesis(dataDir, other); err == nil {
+		t.Fatal("initializing with the genesis of another chain should fail")
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.Balances[acc] != 500 {
+		t.Fatalf("genesis balance should be 500, got %d", state.Balances[acc])
+	}
+
+	if state.genesis.InitialReward() != 7 || state.genesis.InitialDifficulty() != DefaultDifficulty {
+		t.Fatal("chain params should be loaded from the genesis, falling back to the defaults")
+	}
+}
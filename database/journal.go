@@ -0,0 +1,203 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// journalEntry undoes a single change made to a State.
+type journalEntry interface {
+	undo(s *State)
+}
+
+type balanceChange struct {
+	account common.Address
+	prev    uint
+	existed bool
+}
+
+type nonceChange struct {
+	account common.Address
+	prev    uint
+	existed bool
+}
+
+type assetBalanceChange struct {
+	asset   string
+	account common.Address
+	prev    uint
+	existed bool
+}
+
+type multisigChange struct {
+	account common.Address
+	prev    MultisigPolicy
+	existed bool
+}
+
+type headChange struct {
+	hash            Hash
+	block           Block
+	hasGenesisBlock bool
+}
+
+func (c balanceChange) undo(s *State) {
+	if c.existed {
+		s.Balances[c.account] = c.prev
+	} else {
+		delete(s.Balances, c.account)
+	}
+}
+
+func (c nonceChange) undo(s *State) {
+	if c.existed {
+		s.Account2Nonce[c.account] = c.prev
+	} else {
+		delete(s.Account2Nonce, c.account)
+	}
+}
+
+func (c assetBalanceChange) undo(s *State) {
+	if c.existed {
+		s.AssetBalances[c.asset][c.account] = c.prev
+	} else {
+		delete(s.AssetBalances[c.asset], c.account)
+	}
+}
+
+func (c multisigChange) undo(s *State) {
+	if c.existed {
+		s.Multisigs[c.account] = c.prev
+	} else {
+		delete(s.Multisigs, c.account)
+	}
+}
+
+func (c headChange) undo(s *State) {
+	s.latestBlockHash = c.hash
+	s.latestBlock = c.block
+	s.hasGenesisBlock = c.hasGenesisBlock
+}
+
+// journal records the changes made to a State since its oldest open
+// checkpoint. Checkpoints nest, reverting one also reverts the checkpoints
+// taken after it.
+type journal struct {
+	entries     []journalEntry
+	checkpoints []int
+}
+
+func (s *State) record(entry journalEntry) {
+	if s.journal != nil && len(s.journal.checkpoints) > 0 {
+		s.journal.entries = append(s.journal.entries, entry)
+	}
+}
+
+// checkpoint marks the current state so every change made after it can be
+// reverted, and returns its id.
+func (s *State) checkpoint() int {
+	if s.journal == nil {
+		s.journal = &journal{}
+	}
+
+	s.journal.checkpoints = append(s.journal.checkpoints, len(s.journal.entries))
+
+	return len(s.journal.checkpoints) - 1
+}
+
+// revertToCheckpoint undoes every change made since checkpoint id was taken
+// and closes it along with the checkpoints nested in it.
+func (s *State) revertToCheckpoint(id int) {
+	s.checkCheckpoint(id)
+
+	start := s.journal.checkpoints[id]
+	for i := len(s.journal.entries) - 1; i >= start; i-- {
+		s.journal.entries[i].undo(s)
+	}
+
+	s.journal.entries = s.journal.entries[:start]
+	s.journal.checkpoints = s.journal.checkpoints[:id]
+}
+
+// commitCheckpoint keeps the changes made since checkpoint id was taken and
+// closes it along with the checkpoints nested in it. The changes are still
+// reverted with an enclosing checkpoint.
+func (s *State) commitCheckpoint(id int) {
+	s.checkCheckpoint(id)
+
+	s.journal.checkpoints = s.journal.checkpoints[:id]
+	if id == 0 {
+		s.journal.entries = s.journal.entries[:0]
+	}
+}
+
+func (s *State) checkCheckpoint(id int) {
+	if s.journal == nil || id < 0 || id >= len(s.journal.checkpoints) {
+		panic(fmt.Sprintf("state checkpoint %d is not open", id))
+	}
+}
+
+// changedAccounts returns the accounts whose balance in each asset changed
+// since the oldest open checkpoint, TUB included.
+func (s *State) changedAccounts() map[string][]common.Address {
+	accounts := make(map[string][]common.Address)
+	if s.journal == nil {
+		return accounts
+	}
+
+	seen := make(map[string]map[common.Address]bool)
+	add := func(asset string, account common.Address) {
+		if seen[asset] == nil {
+			seen[asset] = make(map[common.Address]bool)
+		}
+
+		if !seen[asset][account] {
+			seen[asset][account] = true
+			accounts[asset] = append(accounts[asset], account)
+		}
+	}
+
+	for _, entry := range s.journal.entries {
+		switch c := entry.(type) {
+		case balanceChange:
+			add(NativeAsset, c.account)
+		case assetBalanceChange:
+			add(c.asset, c.account)
+		}
+	}
+
+	return accounts
+}
+
+func (s *State) setBalance(account common.Address, balance uint) {
+	prev, existed := s.Balances[account]
+	s.record(balanceChange{account, prev, existed})
+
+	s.Balances[account] = balance
+}
+
+func (s *State) setNonce(account common.Address, nonce uint) {
+	prev, existed := s.Account2Nonce[account]
+	s.record(nonceChange{account, prev, existed})
+
+	s.Account2Nonce[account] = nonce
+}
+
+func (s *State) setHead(hash Hash, b Block) {
+	s.record(headChange{s.latestBlockHash, s.latestBlock, s.hasGenesisBlock})
+
+	s.latestBlockHash = hash
+	s.latestBlock = b
+	s.hasGenesisBlock = true
+}
+
+// commit makes the ledger of overlay o the one of s. The caller holds s.mu.
+func (s *State) commit(o *State) {
+	s.Balances = o.Balances
+	s.AssetBalances = o.AssetBalances
+	s.Multisigs = o.Multisigs
+	s.Account2Nonce = o.Account2Nonce
+	s.latestBlockHash = o.latestBlockHash
+	s.latestBlock = o.latestBlock
+	s.hasGenesisBlock = o.hasGenesisBlock
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"os"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	a, b := NewAccount("0x1"), NewAccount("0x2")
+	s := createTestState(AccountState{a, 10, 0})
+
+	outer := s.checkpoint()
+	s.setBalance(a, 5)
+	s.setNonce(a, 1)
+
+	inner := s.checkpoint()
+	s.setBalance(b, 7)
+	s.setHead(Hash{1}, Block{})
+
+	s.revertToCheckpoint(inner)
+	if _, ok := s.Balances[b]; ok || s.hasGenesisBlock || s.Balances[a] != 5 {
+		t.Fatalf("reverting the inner checkpoint should only undo its changes, got %v", s.Balances)
+	}
+
+	s.revertToCheckpoint(outer)
+	if nonce, ok := s.Account2Nonce[a]; !ok || nonce != 0 || s.Balances[a] != 10 {
+		t.Fatalf("reverting the outer checkpoint should restore every field, got %v and %v", s.Balances, s.Account2Nonce)
+	}
+
+	outer = s.checkpoint()
+	inner = s.checkpoint()
+	s.setBalance(b, 3)
+	s.commitCheckpoint(inner)
+	s.revertToCheckpoint(outer)
+	if _, ok := s.Balances[b]; ok {
+		t.Fatal("changes of a committed checkpoint should be reverted with the enclosing one")
+	}
+
+	outer = s.checkpoint()
+	s.setBalance(b, 3)
+	s.commitCheckpoint(outer)
+	if s.Balances[b] != 3 || len(s.journal.entries) != 0 {
+		t.Fatalf("committing the outer checkpoint should keep its changes and empty the journal, got %d entries", len(s.journal.entries))
+	}
+}
+
+func TestApplyBlock_InvalidTxLeavesStateIntact(t *testing.T) {
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(privKey.PublicKey)
+
+	s, dataDir := newFundedTestChainState(t, "bar", map[common.Address]uint{sender: 100})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	mineTestBlock(t, s)
+	balances, nonces := s.Balances[sender], s.Account2Nonce[sender]
+
+	signTx := func(value uint, nonce uint) SignedTx {
+		tx := NewTx(NewAccount("0x2"), sender, value, 0, nonce, "")
+		tx.ChainId = "bar"
+		tx.Time = s.LatestBlock().Header.Time
+
+		return signTestTx(t, tx, privKey)
+	}
+	valid, overspent, next := signTx(10, 1), signTx(1000, 2), signTx(1, 3)
+
+	difficulty, err := s.NextDifficulty(s.LatestBlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockTime, err := s.NextBlockTime(s.LatestBlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for nonce := uint32(0); ; nonce++ {
+		b, err := NewBlock(s.LatestBlockHash(), s.NextBlockNumber(), nonce, difficulty, blockTime, NewAccount("0x9"), Hash{}, []SignedTx{valid, overspent})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !IsBlockHashValid(hash, difficulty) {
+			continue
+		}
+
+		if err := applyBlock(b, s); err == nil {
+			t.Fatal("a block overspending the balance of the sender should be invalid")
+		}
+		break
+	}
+
+	if s.Balances[sender] != balances || s.Account2Nonce[sender] != nonces {
+		t.Fatalf("a rejected block should leave the sender with %d TUB and nonce %d, got %d and %d", balances, nonces, s.Balances[sender], s.Account2Nonce[sender])
+	}
+
+	dryRun := s.DryRunTxs([]SignedTx{valid, overspent, next}, NewAccount("0x9"), blockTime)
+	if len(dryRun.Applied) != 1 || len(dryRun.Rejected) != 2 || dryRun.Rejected[1].Tx.Nonce != 3 {
+		t.Fatalf("only the first TX should apply, the following ones of the sender should be rejected, got %+v", dryRun)
+	}
+
+	if s.Balances[sender] != balances {
+		t.Fatal("a dry run should not change the state")
+	}
+
+	stateRoot, err := s.PendingStateRoot([]SignedTx{valid}, NewAccount("0x9"), blockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dryRun.StateRoot != stateRoot {
+		t.Fatalf("dry run state root should be '%s', got '%s'", stateRoot.Hex(), dryRun.StateRoot.Hex())
+	}
+}
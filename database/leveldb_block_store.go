@@ -0,0 +1,203 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var levelDbBlockPrefix = []byte("b")
+var levelDbHeightPrefix = []byte("h")
+var levelDbSequencePrefix = []byte("s")
+
+// levelDbBlockStore keeps blocks in an embedded LevelDB database:
+//
+//	b<hash>     -> BlockFs JSON
+//	h<number>   -> block hash of the canonical chain
+//	s<sequence> -> block hash, in append order
+type levelDbBlockStore struct {
+	db      *leveldb.DB
+	nextSeq uint64
+}
+
+func openLevelDbBlockStore(dataDir string) (*levelDbBlockStore, error) {
+	db, err := leveldb.OpenFile(getBlocksLevelDbDirPath(dataDir), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &levelDbBlockStore{db: db}
+
+	it := db.NewIterator(util.BytesPrefix(levelDbSequencePrefix), nil)
+	if it.Last() {
+		store.nextSeq = binary.BigEndian.Uint64(it.Key()[len(levelDbSequencePrefix):]) + 1
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// openReadOnlyLevelDbBlockStore opens the LevelDB blocks without writing to
+// them, its Append and SetHead fail.
+func openReadOnlyLevelDbBlockStore(dataDir string) (*levelDbBlockStore, error) {
+	db, err := leveldb.OpenFile(getBlocksLevelDbDirPath(dataDir), &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelDbBlockStore{db: db}, nil
+}
+
+func levelDbKey(prefix []byte, suffix []byte) []byte {
+	return append(append([]byte{}, prefix...), suffix...)
+}
+
+func levelDbUint64Key(prefix []byte, n uint64) []byte {
+	suffix := make([]byte, 8)
+	binary.BigEndian.PutUint64(suffix, n)
+
+	return levelDbKey(prefix, suffix)
+}
+
+func (ls *levelDbBlockStore) Append(blockFs BlockFs) error {
+	blockFsRaw, err := blockFs.encode()
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(levelDbKey(levelDbBlockPrefix, blockFs.Key[:]), blockFsRaw)
+	batch.Put(levelDbUint64Key(levelDbSequencePrefix, ls.nextSeq), blockFs.Key[:])
+
+	if err := ls.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	ls.nextSeq++
+
+	return nil
+}
+
+func (ls *levelDbBlockStore) SetHead(head Hash) error {
+	headBlockFs, err := ls.get(head)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	for hash := head; !hash.IsEmpty(); {
+		blockFs, err := ls.get(hash)
+		if err != nil {
+			return err
+		}
+
+		heightKey := levelDbUint64Key(levelDbHeightPrefix, blockFs.Value.Header.Number)
+		canonical, err := ls.db.Get(heightKey, nil)
+		if err == nil && bytes.Equal(canonical, hash[:]) {
+			break
+		}
+		if err != nil && err != leveldb.ErrNotFound {
+			return err
+		}
+
+		batch.Put(heightKey, hash[:])
+		hash = blockFs.Value.Header.Parent
+	}
+
+	above := &util.Range{
+		Start: levelDbUint64Key(levelDbHeightPrefix, headBlockFs.Value.Header.Number+1),
+		Limit: util.BytesPrefix(levelDbHeightPrefix).Limit,
+	}
+
+	it := ls.db.NewIterator(above, nil)
+	for it.Next() {
+		batch.Delete(append([]byte{}, it.Key()...))
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return ls.db.Write(batch, nil)
+}
+
+func (ls *levelDbBlockStore) Iterate(fn func(blockFs BlockFs) error) error {
+	it := ls.db.NewIterator(util.BytesPrefix(levelDbSequencePrefix), nil)
+	defer it.Release()
+
+	for it.Next() {
+		var hash Hash
+		copy(hash[:], it.Value())
+
+		blockFs, err := ls.get(hash)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(blockFs); err != nil {
+			return err
+		}
+	}
+
+	return it.Error()
+}
+
+func (ls *levelDbBlockStore) get(hash Hash) (BlockFs, error) {
+	blockFsRaw, err := ls.db.Get(levelDbKey(levelDbBlockPrefix, hash[:]), nil)
+	if err == leveldb.ErrNotFound {
+		return BlockFs{}, fmt.Errorf("%w: hash '%s'", ErrBlockNotFound, hash.Hex())
+	}
+	if err != nil {
+		return BlockFs{}, err
+	}
+
+	// Blocks stored before the binary encoding are JSON.
+	if bytes.HasPrefix(blockFsRaw, []byte("{")) {
+		var blockFs BlockFs
+		if err := json.Unmarshal(blockFsRaw, &blockFs); err != nil {
+			return BlockFs{}, err
+		}
+
+		return blockFs, nil
+	}
+
+	return decodeBlockFs(blockFsRaw)
+}
+
+func (ls *levelDbBlockStore) GetByHash(hash Hash) (Block, error) {
+	blockFs, err := ls.get(hash)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return blockFs.Value, nil
+}
+
+func (ls *levelDbBlockStore) GetByHeight(number uint64) (Block, error) {
+	hashBytes, err := ls.db.Get(levelDbUint64Key(levelDbHeightPrefix, number), nil)
+	if err == leveldb.ErrNotFound {
+		return Block{}, fmt.Errorf("%w: number '%d'", ErrBlockNotFound, number)
+	}
+	if err != nil {
+		return Block{}, err
+	}
+
+	var hash Hash
+	copy(hash[:], hashBytes)
+
+	return ls.GetByHash(hash)
+}
+
+func (ls *levelDbBlockStore) Close() error {
+	return ls.db.Close()
+}
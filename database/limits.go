@@ -0,0 +1,141 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math"
+)
+
+const DefaultMaxBlockBytes = 1 << 20
+const DefaultMaxBlockTxs = 1000
+const DefaultMaxReasonLength = 256
+
+// BlockLimits bound the size of blocks and txs so a block can always be
+// stored, synced and verified by any node of the chain.
+type BlockLimits struct {
+	MaxBlockBytes   uint64 `json:"max_block_bytes"`
+	MaxBlockTxs     uint64 `json:"max_block_txs"`
+	MaxReasonLength uint64 `json:"max_reason_length"`
+}
+
+func DefaultBlockLimits() BlockLimits {
+	return Genesis{}.Limits()
+}
+
+// Limits returns the block limits of the chain, falling back to the
+// defaults for limits left to 0.
+func (g Genesis) Limits() BlockLimits {
+	limits := BlockLimits{g.MaxBlockBytes, g.MaxBlockTxs, g.MaxReasonLength}
+
+	if limits.MaxBlockBytes == 0 {
+		limits.MaxBlockBytes = DefaultMaxBlockBytes
+	}
+
+	if limits.MaxBlockTxs == 0 {
+		limits.MaxBlockTxs = DefaultMaxBlockTxs
+	}
+
+	if limits.MaxReasonLength == 0 {
+		limits.MaxReasonLength = DefaultMaxReasonLength
+	}
+
+	return limits
+}
+
+func (s *State) BlockLimits() BlockLimits {
+	return s.genesis.Limits()
+}
+
+// Size returns the number of bytes the block takes once encoded.
+func (b Block) Size() (uint64, error) {
+	blockRaw, err := b.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(len(blockRaw)), nil
+}
+
+func (l BlockLimits) validateBlock(b Block) error {
+	if uint64(len(b.Txs)) > l.MaxBlockTxs {
+		return fmt.Errorf("block has %d TXs, at most %d are allowed", len(b.Txs), l.MaxBlockTxs)
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		return err
+	}
+
+	if size > l.MaxBlockBytes {
+		return fmt.Errorf("block takes %d bytes, at most %d are allowed", size, l.MaxBlockBytes)
+	}
+
+	return nil
+}
+
+func (l BlockLimits) validateTx(tx SignedTx) error {
+	if uint64(len(tx.Reason)) > l.MaxReasonLength {
+		return fmt.Errorf("wrong TX. Reason is %d bytes long, at most %d are allowed", len(tx.Reason), l.MaxReasonLength)
+	}
+
+	return nil
+}
+
+// maxHeaderBlockSize is the size of a block without txs whose header fields
+// all take their longest encoding, plus room for the headers of the block
+// and txs lists to grow from 1 to 9 bytes.
+func maxHeaderBlockSize() (uint64, error) {
+	header := BlockHeader{
+		Number:     math.MaxUint64,
+		Nonce:      math.MaxUint32,
+		Difficulty: math.MaxUint64,
+		Time:       math.MaxUint64,
+		Fees:       ^uint(0),
+	}
+
+	size, err := Block{header, []SignedTx{}}.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	return size + 2*8, nil
+}
+
+// SelectTxs picks txs, in order, until a block of time blockTime is full.
+// A tx that doesn't fit or is newer than the block is skipped along with
+// every later tx of its sender, which could only follow it by nonce.
+func (l BlockLimits) SelectTxs(txs []SignedTx, blockTime uint64) ([]SignedTx, error) {
+	size, err := maxHeaderBlockSize()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]SignedTx, 0, len(txs))
+	skipped := make(map[common.Address]bool)
+
+	for _, tx := range txs {
+		if uint64(len(selected)) == l.MaxBlockTxs {
+			break
+		}
+
+		if skipped[tx.From] {
+			continue
+		}
+
+		txRaw, err := tx.Encode()
+		if err != nil {
+			return nil, err
+		}
+
+		txSize := uint64(len(txRaw))
+		if l.validateTx(tx) != nil || tx.Time > blockTime || size+txSize > l.MaxBlockBytes {
+			skipped[tx.From] = true
+			continue
+		}
+
+		size += txSize
+		selected = append(selected, tx)
+	}
+
+	return selected, nil
+}
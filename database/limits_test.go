@@ -0,0 +1,77 @@
+package database
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBlockLimits_SelectTxs(t *testing.T) {
+	txs := createTestTxs(5)
+
+	selected, err := BlockLimits{MaxBlockBytes: DefaultMaxBlockBytes, MaxBlockTxs: 3, MaxReasonLength: 8}.SelectTxs(txs, math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(selected) != 3 {
+		t.Fatalf("only 3 TXs should fit a block, got %d", len(selected))
+	}
+
+	long := NewTx(NewAccount("0x4"), NewAccount("0x3"), 1, 0, 1, strings.Repeat("a", 9))
+	next := NewTx(NewAccount("0x4"), NewAccount("0x3"), 1, 0, 2, "")
+	txs = append([]SignedTx{NewSignedTx(long, nil), NewSignedTx(next, nil)}, txs...)
+
+	selected, err = BlockLimits{MaxBlockBytes: DefaultMaxBlockBytes, MaxBlockTxs: 10, MaxReasonLength: 8}.SelectTxs(txs, math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(selected) != 5 || selected[0].From != NewAccount("0x1") {
+		t.Fatal("a TX with a too long reason should be skipped along with the later TXs of its sender")
+	}
+
+	headerSize, err := maxHeaderBlockSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := BlockLimits{MaxBlockBytes: headerSize + 150, MaxBlockTxs: 10, MaxReasonLength: 8}
+	selected, err = limits.SelectTxs(createTestTxs(5), math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(selected) == 0 || len(selected) == 5 {
+		t.Fatalf("some but not all TXs should fit in %d bytes, got %d", limits.MaxBlockBytes, len(selected))
+	}
+
+	b, err := NewBlock(Hash{}, 0, 0, 1, 1, NewAccount("0x9"), Hash{}, selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := limits.validateBlock(b); err != nil {
+		t.Fatalf("a block of the selected TXs should be valid. %s", err)
+	}
+
+	b, err = NewBlock(Hash{}, 0, 0, 1, 1, NewAccount("0x9"), Hash{}, createTestTxs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := limits.validateBlock(b); err == nil {
+		t.Fatal("a block above the max block bytes should be invalid")
+	}
+}
+
+func TestApplyTx_ReasonTooLong(t *testing.T) {
+	s := createTestState(AccountState{NewAccount("0x1"), 100, 0})
+	s.genesis = Genesis{MaxReasonLength: 4}
+
+	tx := NewTx(NewAccount("0x2"), NewAccount("0x1"), 1, 0, 1, "too long")
+	err := applyTx(NewSignedTx(tx, nil), 0, 0, s)
+	if err == nil || !strings.Contains(err.Error(), "Reason") {
+		t.Fatalf("a TX with a too long reason should be rejected, got %v", err)
+	}
+}
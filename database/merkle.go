@@ -0,0 +1,180 @@
+package database
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so a proof for
+// an inner node can never be passed off as a proof for a transaction.
+const merkleLeafPrefix = 0x00
+const merkleNodePrefix = 0x01
+
+// MerkleStep is a sibling on the path from a transaction up to the root.
+// Left tells whether the sibling is hashed on the left side.
+type MerkleStep struct {
+	Hash Hash `json:"hash"`
+	Left bool `json:"left"`
+}
+
+// TxProof proves a transaction is part of a block, given only the block
+// header instead of the whole block.
+type TxProof struct {
+	BlockHash Hash         `json:"block_hash"`
+	Header    BlockHeader  `json:"header"`
+	Tx        SignedTx     `json:"tx"`
+	Index     int          `json:"index"`
+	Path      []MerkleStep `json:"path"`
+}
+
+func txLeaf(tx SignedTx) (Hash, error) {
+	txRaw, err := tx.Encode()
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, txRaw...)), nil
+}
+
+func merkleParent(left Hash, right Hash) Hash {
+	content := make([]byte, 0, 1+2*len(left))
+	content = append(content, merkleNodePrefix)
+	content = append(content, left[:]...)
+	content = append(content, right[:]...)
+
+	return sha256.Sum256(content)
+}
+
+func txLeaves(txs []SignedTx) ([]Hash, error) {
+	leaves := make([]Hash, len(txs))
+	for i, tx := range txs {
+		leaf, err := txLeaf(tx)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+
+	return leaves, nil
+}
+
+// merkleLevel hashes the nodes of a level pairwise. A trailing node without
+// a sibling is promoted to the next level as is.
+func merkleLevel(nodes []Hash) []Hash {
+	next := make([]Hash, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			next = append(next, nodes[i])
+			continue
+		}
+
+		next = append(next, merkleParent(nodes[i], nodes[i+1]))
+	}
+
+	return next
+}
+
+// TxRoot returns the Merkle root of txs in their block order. A block
+// without txs has an empty root.
+func TxRoot(txs []SignedTx) (Hash, error) {
+	if len(txs) == 0 {
+		return Hash{}, nil
+	}
+
+	nodes, err := txLeaves(txs)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	for len(nodes) > 1 {
+		nodes = merkleLevel(nodes)
+	}
+
+	return nodes[0], nil
+}
+
+func validateTxRoot(b Block) error {
+	txRoot, err := TxRoot(b.Txs)
+	if err != nil {
+		return err
+	}
+
+	if txRoot != b.Header.TxRoot {
+		return fmt.Errorf("block tx root must be '%s' not '%s'", txRoot.Hex(), b.Header.TxRoot.Hex())
+	}
+
+	return nil
+}
+
+// NewTxProof builds the inclusion proof of the tx at index of block b.
+func NewTxProof(b Block, index int) (TxProof, error) {
+	if index < 0 || index >= len(b.Txs) {
+		return TxProof{}, fmt.Errorf("block has no tx at index %d", index)
+	}
+
+	blockHash, err := b.Hash()
+	if err != nil {
+		return TxProof{}, err
+	}
+
+	nodes, err := txLeaves(b.Txs)
+	if err != nil {
+		return TxProof{}, err
+	}
+
+	path := make([]MerkleStep, 0)
+	for i := index; len(nodes) > 1; i /= 2 {
+		if i%2 == 1 {
+			path = append(path, MerkleStep{nodes[i-1], true})
+		} else if i+1 < len(nodes) {
+			path = append(path, MerkleStep{nodes[i+1], false})
+		}
+
+		nodes = merkleLevel(nodes)
+	}
+
+	return TxProof{blockHash, b.Header, b.Txs[index], index, path}, nil
+}
+
+// Verify checks the tx hashes up to the tx root of the header and that the
+// header is the one of block BlockHash.
+func (p TxProof) Verify() error {
+	blockHash, err := Block{Header: p.Header}.Hash()
+	if err != nil {
+		return err
+	}
+
+	if blockHash != p.BlockHash {
+		return fmt.Errorf("header hashes to '%s', not block '%s'", blockHash.Hex(), p.BlockHash.Hex())
+	}
+
+	node, err := txLeaf(p.Tx)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range p.Path {
+		if step.Left {
+			node = merkleParent(step.Hash, node)
+		} else {
+			node = merkleParent(node, step.Hash)
+		}
+	}
+
+	if node != p.Header.TxRoot {
+		return fmt.Errorf("proof leads to root '%s', not the block tx root '%s'", node.Hex(), p.Header.TxRoot.Hex())
+	}
+
+	return nil
+}
+
+// GetTxProof returns the inclusion proof of the canonical tx with hash
+// txHash.
+func (s *State) GetTxProof(txHash Hash) (TxProof, error) {
+	b, index, err := s.findTx(txHash)
+	if err != nil {
+		return TxProof{}, err
+	}
+
+	return NewTxProof(b, index)
+}
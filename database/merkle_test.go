@@ -0,0 +1,64 @@
+package database
+
+import (
+	"testing"
+)
+
+func createTestTxs(count int) []SignedTx {
+	txs := make([]SignedTx, 0, count)
+	for i := 0; i < count; i++ {
+		tx := NewTx(NewAccount("0x2"), NewAccount("0x1"), uint(i+1), 0, uint(i+1), "")
+		txs = append(txs, NewSignedTx(tx, []byte{byte(i)}))
+	}
+
+	return txs
+}
+
+func TestTxProof(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		b, err := NewBlock(Hash{}, 0, 0, DefaultDifficulty, 0, NewAccount(""), Hash{}, createTestTxs(count))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range b.Txs {
+			proof, err := NewTxProof(b, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := proof.Verify(); err != nil {
+				t.Fatalf("proof of tx %d out of %d should be valid. %s", i, count, err)
+			}
+		}
+	}
+}
+
+func TestTxProof_Tampered(t *testing.T) {
+	b, err := NewBlock(Hash{}, 0, 0, DefaultDifficulty, 0, NewAccount(""), Hash{}, createTestTxs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := NewTxProof(b, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forgedTx := proof
+	forgedTx.Tx.Value++
+	if err := forgedTx.Verify(); err == nil {
+		t.Fatal("proof of a modified tx should be invalid")
+	}
+
+	forgedHeader := proof
+	forgedHeader.Header.TxRoot = Hash{1}
+	if err := forgedHeader.Verify(); err == nil {
+		t.Fatal("proof against a header not matching the block hash should be invalid")
+	}
+
+	b.Txs[0], b.Txs[1] = b.Txs[1], b.Txs[0]
+	if err := validateTxRoot(b); err == nil {
+		t.Fatal("reordering the txs of a block should change its tx root")
+	}
+}
@@ -0,0 +1,436 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the layout of the data dir this build reads and writes.
+// Data dirs written before the version marker existed are version 0.
+const SchemaVersion = 1
+
+// Migration upgrades a data dir from the previous schema version to Version.
+type Migration struct {
+	Version     int
+	Description string
+	apply       func(dataDir string) error
+}
+
+// migrations are applied in order, each one to the data dir left by the
+// previous one. A format change comes with a new entry and SchemaVersion bump.
+var migrations = []Migration{
+	{1, "mark data dirs storing binary hashed blocks as versioned, refusing chains hashed as JSON", migrateBlocksToBinary},
+}
+
+type MigrationReport struct {
+	From    int
+	To      int
+	Applied []Migration
+	Backup  string
+	DryRun  bool
+}
+
+// DataDirVersion returns the schema version recorded in the data dir.
+func DataDirVersion(dataDir string) (int, error) {
+	content, err := ioutil.ReadFile(getSchemaVersionFilePath(dataDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version in '%s'. %s", getSchemaVersionFilePath(dataDir), err.Error())
+	}
+
+	return version, nil
+}
+
+func writeDataDirVersion(dataDir string, version int) error {
+	return writeFileAtomically(getSchemaVersionFilePath(dataDir), []byte(fmt.Sprintf("%d\n", version)))
+}
+
+// PendingMigrations returns the migrations upgrading dataDir to SchemaVersion.
+func PendingMigrations(dataDir string) ([]Migration, error) {
+	version, err := DataDirVersion(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("data dir '%s' has schema version %d, this build only knows up to %d", dataDir, version, SchemaVersion)
+	}
+
+	pending := make([]Migration, 0)
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// checkDataDirVersion refuses data dirs written by another schema version.
+// A legacy data dir holding no blocks yet has nothing to migrate and is
+// marked as current right away, unless readOnly.
+func checkDataDirVersion(dataDir string, readOnly bool) error {
+	pending, err := PendingMigrations(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	hasBlocks, err := fileBlockStoreHasBlocks(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if !hasBlocks && !fileExist(getBlocksLevelDbDirPath(dataDir)) {
+		if readOnly {
+			return nil
+		}
+
+		return writeDataDirVersion(dataDir, SchemaVersion)
+	}
+
+	return fmt.Errorf("data dir '%s' has schema version %d but %d is required, run 'tub migrate' to upgrade it", dataDir, pending[0].Version-1, SchemaVersion)
+}
+
+// MigrateDataDir applies every pending migration to dataDir. With backup, the
+// database dir is copied next to it first. A dry run applies the migrations
+// to a temporary copy instead, leaving dataDir untouched.
+func MigrateDataDir(dataDir string, dryRun bool, backup bool) (MigrationReport, error) {
+	if !fileExist(getGenesisJsonFilePath(dataDir)) {
+		return MigrationReport{}, fmt.Errorf("data dir '%s' has no genesis", dataDir)
+	}
+
+	from, err := DataDirVersion(dataDir)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+
+	pending, err := PendingMigrations(dataDir)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+
+	report := MigrationReport{From: from, To: from, DryRun: dryRun}
+	if len(pending) == 0 {
+		return report, nil
+	}
+
+	target := dataDir
+	if dryRun {
+		target, err = copyDataDir(dataDir)
+		if err != nil {
+			return report, err
+		}
+		defer os.RemoveAll(target)
+	} else if backup {
+		report.Backup, err = BackupDatabase(dataDir)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	for _, m := range pending {
+		if err := m.apply(target); err != nil {
+			return report, fmt.Errorf("migration to version %d failed. %s", m.Version, err.Error())
+		}
+
+		if err := writeDataDirVersion(target, m.Version); err != nil {
+			return report, err
+		}
+
+		report.Applied = append(report.Applied, m)
+		report.To = m.Version
+	}
+
+	return report, nil
+}
+
+// ConvertBlockStore moves the blocks of a file backend data dir into a LevelDB
+// store, keeping the canonical head, and returns how many were moved. The
+// emptied block.db is left behind, the data dir is detected as a LevelDB one.
+// A dry run converts a temporary copy instead.
+func ConvertBlockStore(dataDir string, backend string, dryRun bool) (int, error) {
+	if dryRun {
+		tmpDir, err := copyDataDir(dataDir)
+		if err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		return ConvertBlockStore(tmpDir, backend, false)
+	}
+
+	if backend != BackendLevelDb {
+		return 0, fmt.Errorf("blocks can only be converted to the '%s' backend", BackendLevelDb)
+	}
+
+	if err := checkDataDirVersion(dataDir, false); err != nil {
+		return 0, err
+	}
+
+	if DetectBackend(dataDir) == BackendLevelDb {
+		return 0, fmt.Errorf("data dir '%s' already stores blocks using the '%s' backend", dataDir, BackendLevelDb)
+	}
+
+	source, err := openFileBlockStore(dataDir)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	target, err := openLevelDbBlockStore(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = source.Iterate(func(blockFs BlockFs) error {
+		count++
+		return target.Append(blockFs)
+	})
+
+	if number, ok := source.index.latestNumber(); err == nil && ok {
+		head, _ := source.index.hashByHeight(number)
+		err = target.SetHead(head)
+	}
+
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.RemoveAll(getBlocksLevelDbDirPath(dataDir))
+		return 0, err
+	}
+
+	if err := source.dbFile.Truncate(0); err != nil {
+		return count, err
+	}
+
+	for _, path := range []string{getBlocksIndexFilePath(dataDir), getBlocksHeadFilePath(dataDir)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// migrateBlocksToBinary versions the data dirs written since blocks are hashed
+// as their binary header, re-encoding the few of their records still stored
+// as JSON. Blocks written before the binary encoding were hashed, mined and
+// signed over their JSON, and before that had no difficulty nor roots in
+// their header. Re-hashing them would break the proof of work, state roots
+// and tx signatures every block is checked against when loaded, so no
+// rewrite can carry such a chain over: the migration refuses it and the data
+// dir has to be synced again from peers.
+func migrateBlocksToBinary(dataDir string) error {
+	if DetectBackend(dataDir) == BackendLevelDb {
+		db, err := leveldb.OpenFile(getBlocksLevelDbDirPath(dataDir), nil)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		batch := new(leveldb.Batch)
+		it := db.NewIterator(util.BytesPrefix(levelDbBlockPrefix), nil)
+		for it.Next() {
+			if !bytes.HasPrefix(it.Value(), []byte("{")) {
+				continue
+			}
+
+			var blockFs BlockFs
+			if err := json.Unmarshal(it.Value(), &blockFs); err != nil {
+				it.Release()
+				return err
+			}
+
+			if err := checkLegacyBlockHash(blockFs); err != nil {
+				it.Release()
+				return err
+			}
+
+			blockFsRaw, err := blockFs.encode()
+			if err != nil {
+				it.Release()
+				return err
+			}
+
+			batch.Put(append([]byte{}, it.Key()...), blockFsRaw)
+		}
+		it.Release()
+
+		if err := it.Error(); err != nil {
+			return err
+		}
+
+		return db.Write(batch, nil)
+	}
+
+	f, err := os.Open(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmpPath := getBlocksDBFilePath(dataDir) + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	validEnd, err := scanBlockRecords(f, 0, func(blockFs BlockFs, offset int64, length uint32) error {
+		if err := checkLegacyBlockHash(blockFs); err != nil {
+			return err
+		}
+
+		record, err := encodeBlockRecord(blockFs)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(record)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if validEnd != stat.Size() {
+		return fmt.Errorf("block.db is corrupted past offset %d, run 'tub db repair' first", validEnd)
+	}
+
+	if err := os.Rename(tmpPath, getBlocksDBFilePath(dataDir)); err != nil {
+		return err
+	}
+
+	// Record offsets changed, the index is rebuilt on the next open.
+	if err := os.Remove(getBlocksIndexFilePath(dataDir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// checkLegacyBlockHash refuses a stored block whose hash isn't the one of its
+// binary encoded header.
+func checkLegacyBlockHash(blockFs BlockFs) error {
+	hash, err := blockFs.Value.Hash()
+	if err != nil {
+		return err
+	}
+
+	if hash != blockFs.Key {
+		return fmt.Errorf("block %d '%s' was hashed by a build older than the binary encoding and can't be carried over with its chain. Start a new data dir and sync it from peers", blockFs.Value.Header.Number, blockFs.Key.Hex())
+	}
+
+	return nil
+}
+
+// BackupDatabase copies the database dir of dataDir next to it and returns
+// the path of the copy.
+func BackupDatabase(dataDir string) (string, error) {
+	version, err := DataDirVersion(dataDir)
+	if err != nil {
+		return "", err
+	}
+
+	path := fmt.Sprintf("%s.v%d-%d.bak", getDatabaseDirPath(dataDir), version, time.Now().Unix())
+	if err := copyDir(getDatabaseDirPath(dataDir), path); err != nil {
+		return "", fmt.Errorf("unable to back up the database. %s", err.Error())
+	}
+
+	return path, nil
+}
+
+// copyDataDir copies the database of dataDir into a temporary data dir.
+func copyDataDir(dataDir string) (string, error) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), ".tub_migrate")
+	if err != nil {
+		return "", err
+	}
+
+	if err := copyDir(getDatabaseDirPath(dataDir), getDatabaseDirPath(tmpDir)); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	return tmpDir, nil
+}
+
+func copyDir(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// removeDataDirVersion makes dataDir look like one written by the builds
+// storing binary blocks before schema versioning.
+func removeDataDirVersion(t *testing.T, dataDir string) {
+	if err := os.Remove(getSchemaVersionFilePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// baselineTx, baselineBlock and baselineBlockFs are the blocks the first
+// builds wrote in block.db, without fees, difficulty nor roots and hashed as
+// their JSON.
+type baselineTx struct {
+	To     common.Address `json:"to"`
+	From   common.Address `json:"from"`
+	Nonce  uint           `json:"nonce"`
+	Value  uint           `json:"value"`
+	Reason string         `json:"reason"`
+	Time   uint64         `json:"time"`
+	Sig    []byte         `json:"signature"`
+}
+
+type baselineBlockHeader struct {
+	Parent Hash           `json:"parent"`
+	Number uint64         `json:"number"`
+	Nonce  uint32         `json:"nonce"`
+	Time   uint64         `json:"time"`
+	Miner  common.Address `json:"miner"`
+}
+
+type baselineBlock struct {
+	Header baselineBlockHeader `json:"header"`
+	Txs    []baselineTx        `json:"payload"`
+}
+
+type baselineBlockFs struct {
+	Key   Hash          `json:"hash"`
+	Value baselineBlock `json:"block"`
+}
+
+// writeBaselineBlocksDb replaces the blocks of dataDir by a chain of count
+// blocks in the format of the first builds, which had no schema version.
+func writeBaselineBlocksDb(t *testing.T, dataDir string, count int) {
+	var content []byte
+	parent := Hash{}
+	for number := 0; number < count; number++ {
+		blockTime := uint64(1600000000 + number)
+		b := baselineBlock{
+			baselineBlockHeader{parent, uint64(number), 0, blockTime, NewAccount("0x9")},
+			[]baselineTx{{NewAccount("0x2"), NewAccount("0x1"), uint(number + 1), 1, "", blockTime, []byte{1}}},
+		}
+
+		blockJson, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := Hash(sha256.Sum256(blockJson))
+
+		blockFsJson, err := json.Marshal(baselineBlockFs{hash, b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(append(content, blockFsJson...), '\n')
+		parent = hash
+	}
+
+	if err := ioutil.WriteFile(getBlocksDBFilePath(dataDir), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{getBlocksIndexFilePath(dataDir), getBlocksHeadFilePath(dataDir), getSchemaVersionFilePath(dataDir)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateDataDir(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+
+	for i := 0; i < 2; i++ {
+		mineTestBlock(t, s)
+	}
+	head := s.LatestBlockHash()
+	s.Close()
+
+	removeDataDirVersion(t, dataDir)
+
+	if _, err := NewStateFromDisk(dataDir); err == nil || !strings.Contains(err.Error(), "tub migrate") {
+		t.Fatalf("a legacy data dir should not be opened before it is migrated, got %v", err)
+	}
+
+	if _, err := VerifyChain(dataDir); err == nil || !strings.Contains(err.Error(), "tub migrate") {
+		t.Fatalf("a legacy data dir should not be verified before it is migrated, got %v", err)
+	}
+
+	legacy, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := MigrateDataDir(dataDir, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.To != SchemaVersion || report.Backup != "" || !bytes.Equal(content, legacy) {
+		t.Fatalf("a dry run should migrate a copy only, got %+v", report)
+	}
+
+	report, err = MigrateDataDir(dataDir, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.From != 0 || report.To != SchemaVersion || len(report.Applied) != len(migrations) || !fileExist(report.Backup) {
+		t.Fatalf("every migration should be applied after a backup, got %+v", report)
+	}
+
+	version, err := DataDirVersion(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != SchemaVersion {
+		t.Fatalf("data dir should be at version %d, got %d", SchemaVersion, version)
+	}
+
+	if _, err := VerifyChain(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+
+	if migrated.LatestBlockHash() != head {
+		t.Fatalf("migrated chain should keep its head '%s', got '%s'", head.Hex(), migrated.LatestBlockHash().Hex())
+	}
+}
+
+func TestMigrateDataDir_RefusesBaselineBlocks(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	writeBaselineBlocksDb(t, dataDir, 2)
+
+	legacy, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStateFromDisk(dataDir); err == nil || !strings.Contains(err.Error(), "tub migrate") {
+		t.Fatalf("a baseline data dir should not be opened before it is migrated, got %v", err)
+	}
+
+	if _, err := MigrateDataDir(dataDir, false, false); err == nil || !strings.Contains(err.Error(), "can't be carried over") {
+		t.Fatalf("blocks hashed as JSON should be refused, got %v", err)
+	}
+
+	content, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := DataDirVersion(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 0 || !bytes.Equal(content, legacy) {
+		t.Fatalf("a refused data dir should be left untouched, got version %d", version)
+	}
+}
+
+func TestRepairBlocksDb_RefusesNewerSchema(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	if err := writeDataDirVersion(dataDir, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RepairBlocksDb(dataDir); err == nil || !strings.Contains(err.Error(), "only knows up to") {
+		t.Fatalf("a data dir written by a newer build should not be repaired, got %v", err)
+	}
+}
+
+func TestConvertBlockStore(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+
+	for i := 0; i < 2; i++ {
+		mineTestBlock(t, s)
+	}
+	head := s.LatestBlockHash()
+	s.Close()
+
+	if _, err := ConvertBlockStore(dataDir, BackendLevelDb, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if DetectBackend(dataDir) != BackendFile {
+		t.Fatal("a dry run should leave the data dir on its backend")
+	}
+
+	count, err := ConvertBlockStore(dataDir, BackendLevelDb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 || DetectBackend(dataDir) != BackendLevelDb {
+		t.Fatalf("2 blocks should be moved to the LevelDB backend, got %d", count)
+	}
+
+	converted, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer converted.Close()
+
+	if converted.LatestBlockHash() != head {
+		t.Fatalf("converted chain should keep its head '%s', got '%s'", head.Hex(), converted.LatestBlockHash().Hex())
+	}
+}
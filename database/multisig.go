@@ -0,0 +1,201 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"sort"
+)
+
+// maxMultisigSigners bounds the signers of a multisig account, and so the
+// cosignatures a tx carries.
+const maxMultisigSigners = 16
+
+// MultisigPolicy makes an account spendable only by txs cosigned by at least
+// Threshold of its Signers. The key of the account itself no longer signs.
+type MultisigPolicy struct {
+	Signers   []common.Address `json:"signers"`
+	Threshold uint             `json:"threshold"`
+}
+
+func (p MultisigPolicy) validate() error {
+	if len(p.Signers) == 0 || len(p.Signers) > maxMultisigSigners {
+		return fmt.Errorf("multisig must have 1 to %d signers, got %d", maxMultisigSigners, len(p.Signers))
+	}
+
+	if p.Threshold == 0 || p.Threshold > uint(len(p.Signers)) {
+		return fmt.Errorf("multisig threshold must be 1 to %d signers, got %d", len(p.Signers), p.Threshold)
+	}
+
+	seen := make(map[common.Address]bool)
+	for _, signer := range p.Signers {
+		if signer == (common.Address{}) {
+			return fmt.Errorf("multisig signer can't be the empty account")
+		}
+
+		if seen[signer] {
+			return fmt.Errorf("multisig signer '%s' is listed twice", signer.Hex())
+		}
+		seen[signer] = true
+	}
+
+	return nil
+}
+
+func (p MultisigPolicy) isSigner(account common.Address) bool {
+	for _, signer := range p.Signers {
+		if signer == account {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsMultisigRegistration tells whether the tx makes its sender a multisig
+// account, or replaces the policy of a multisig sender.
+func (tx Tx) IsMultisigRegistration() bool {
+	return tx.Threshold > 0 || len(tx.Signers) > 0
+}
+
+// MultisigPolicy returns the policy registered by the tx.
+func (tx Tx) MultisigPolicy() MultisigPolicy {
+	return MultisigPolicy{tx.Signers, tx.Threshold}
+}
+
+// Cosigners returns the accounts having cosigned the tx, in the order of its
+// cosignatures.
+func (tx SignedTx) Cosigners() ([]common.Address, error) {
+	cosigners := make([]common.Address, len(tx.Sigs))
+	for i, sig := range tx.Sigs {
+		signer, err := tx.Tx.signer(sig)
+		if err != nil {
+			return nil, err
+		}
+		cosigners[i] = signer
+	}
+
+	return cosigners, nil
+}
+
+// Multisig returns the policy of account, if it is a multisig account.
+func (s *State) Multisig(account common.Address) (MultisigPolicy, bool) {
+	policy, ok := s.Multisigs[account]
+
+	return policy, ok
+}
+
+func (s *State) setMultisig(account common.Address, policy MultisigPolicy) {
+	prev, existed := s.Multisigs[account]
+	s.record(multisigChange{account, prev, existed})
+
+	s.Multisigs[account] = policy
+}
+
+func copyMultisigs(multisigs map[common.Address]MultisigPolicy) map[common.Address]MultisigPolicy {
+	c := make(map[common.Address]MultisigPolicy)
+	for acc, policy := range multisigs {
+		c[acc] = policy
+	}
+
+	return c
+}
+
+// ValidateTxSigs checks the tx is signed by its sender, or cosigned by the
+// threshold of signers of a multisig sender.
+func (s *State) ValidateTxSigs(tx SignedTx) error {
+	policy, ok := s.Multisigs[tx.From]
+	if !ok {
+		if len(tx.Sigs) > 0 {
+			return fmt.Errorf("wrong TX. Sender '%s' is not a multisig account, the TX can't be cosigned", tx.From.String())
+		}
+
+		authentic, err := tx.IsAuthentic()
+		if err != nil {
+			return err
+		}
+
+		if !authentic {
+			return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
+		}
+
+		return nil
+	}
+
+	if len(tx.Sig) > 0 {
+		return fmt.Errorf("wrong TX. Sender '%s' is a multisig account, the TX must only be cosigned", tx.From.String())
+	}
+
+	if len(tx.Sigs) > len(policy.Signers) {
+		return fmt.Errorf("wrong TX. Sender '%s' has %d signers, the TX has %d cosignatures", tx.From.String(), len(policy.Signers), len(tx.Sigs))
+	}
+
+	cosigners, err := tx.Cosigners()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[common.Address]bool)
+	for _, cosigner := range cosigners {
+		if !policy.isSigner(cosigner) {
+			return fmt.Errorf("wrong TX. '%s' is not a signer of multisig '%s'", cosigner.String(), tx.From.String())
+		}
+
+		if seen[cosigner] {
+			return fmt.Errorf("wrong TX. Signer '%s' cosigned the TX twice", cosigner.String())
+		}
+		seen[cosigner] = true
+	}
+
+	if uint(len(cosigners)) < policy.Threshold {
+		return fmt.Errorf("wrong TX. Multisig '%s' needs %d cosignatures, the TX has %d", tx.From.String(), policy.Threshold, len(cosigners))
+	}
+
+	return nil
+}
+
+func multisigLeaf(account common.Address, policy MultisigPolicy) Hash {
+	content := make([]byte, 0, 1+common.AddressLength*(1+len(policy.Signers))+8)
+	content = append(content, merkleLeafPrefix)
+	content = append(content, account[:]...)
+
+	threshold := make([]byte, 8)
+	binary.BigEndian.PutUint64(threshold, uint64(policy.Threshold))
+	content = append(content, threshold...)
+
+	for _, signer := range policy.Signers {
+		content = append(content, signer[:]...)
+	}
+
+	return sha256.Sum256(content)
+}
+
+// multisigsRoot returns the Merkle root of the multisig policies of s, or
+// false when there are none.
+func (s *State) multisigsRoot() (Hash, bool) {
+	if len(s.Multisigs) == 0 {
+		return Hash{}, false
+	}
+
+	accounts := make([]common.Address, 0, len(s.Multisigs))
+	for acc := range s.Multisigs {
+		accounts = append(accounts, acc)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i][:], accounts[j][:]) < 0
+	})
+
+	nodes := make([]Hash, len(accounts))
+	for i, acc := range accounts {
+		nodes[i] = multisigLeaf(acc, s.Multisigs[acc])
+	}
+
+	for len(nodes) > 1 {
+		nodes = merkleLevel(nodes)
+	}
+
+	return nodes[0], true
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"os"
+	"testing"
+)
+
+func cosignTestTx(t *testing.T, tx SignedTx, privKeys ...*ecdsa.PrivateKey) SignedTx {
+	rawTx, err := tx.Tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, privKey := range privKeys {
+		sig, err := crypto.Sign(crypto.Keccak256(rawTx), privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Sigs = append(tx.Sigs, sig)
+	}
+
+	return tx
+}
+
+func TestMultisig(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 5)
+	accounts := make([]common.Address, 5)
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], accounts[i] = key, crypto.PubkeyToAddress(key.PublicKey)
+	}
+	treasury, a, c, outsider := keys[0], keys[1], keys[3], keys[4]
+
+	s, dataDir := newFundedTestChainState(t, "bar", map[common.Address]uint{accounts[0]: 100})
+	defer os.RemoveAll(dataDir)
+
+	mineTestBlock(t, s)
+
+	newTx := func(value uint) Tx {
+		tx := NewTx(NewAccount("0x2"), accounts[0], value, 1, s.GetNextAccountNonce(accounts[0]), "")
+		tx.ChainId = "bar"
+		tx.Time = s.LatestBlock().Header.Time
+
+		return tx
+	}
+
+	registration := newTx(0)
+	registration.Threshold = 2
+	registration.Signers = accounts[1:4]
+	mineTestTxs(t, s, []SignedTx{signTestTx(t, registration, treasury)})
+
+	if policy, ok := s.Multisig(accounts[0]); !ok || policy.Threshold != 2 || len(policy.Signers) != 3 {
+		t.Fatalf("treasury should be a 2 of 3 multisig account, got %+v", policy)
+	}
+
+	payment := NewSignedTx(newTx(10), nil)
+	blockTime, err := s.NextBlockTime(s.LatestBlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := map[string]SignedTx{
+		"signed by the treasury key":  signTestTx(t, payment.Tx, treasury),
+		"cosigned by a single signer": cosignTestTx(t, payment, a),
+		"cosigned by a non signer":    cosignTestTx(t, payment, a, outsider),
+		"cosigned twice by a signer":  cosignTestTx(t, payment, a, a),
+	}
+	for name, tx := range rejected {
+		if dryRun := s.DryRunTxs([]SignedTx{tx}, NewAccount("0x9"), blockTime); len(dryRun.Rejected) != 1 {
+			t.Errorf("TX %s should be rejected", name)
+		}
+	}
+
+	raw, err := cosignTestTx(t, payment, c, a).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeSignedTx(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ValidateTxSigs(decoded); err != nil {
+		t.Fatalf("TX cosigned by 2 signers should be valid. %s", err)
+	}
+
+	mineTestTxs(t, s, []SignedTx{decoded})
+	if s.Balances[NewAccount("0x2")] != 10 {
+		t.Fatalf("cosigned payment should be applied, got %d TUB", s.Balances[NewAccount("0x2")])
+	}
+
+	proof, err := s.GetAccountProof(accounts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proof.Verify(); err != nil || proof.MultisigsRoot == nil {
+		t.Fatalf("account proof should verify against a state root committing to multisigs. %v", err)
+	}
+
+	if _, err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	stateRoot := s.StateRoot()
+	s.Close()
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, ok := s.Multisig(accounts[0]); !ok || s.StateRoot() != stateRoot {
+		t.Fatal("multisig policies should be restored from the snapshot")
+	}
+}
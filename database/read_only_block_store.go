@@ -0,0 +1,130 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// readOnlyBlockStore serves the blocks of a file backend data dir read once
+// from block.db, leaving block.db, block.idx and block.head untouched.
+// Records past the first torn or corrupted one are left out, corruptedAt is
+// the offset of that record, or -1 when block.db is sound.
+type readOnlyBlockStore struct {
+	blocks      []BlockFs
+	byHash      map[Hash]Block
+	byHeight    map[uint64]Hash
+	corruptedAt int64
+}
+
+// openReadOnlyBlockStore opens the blocks of dataDir without writing to it,
+// unlike openBlockStore it never repairs block.db nor rebuilds its index.
+func openReadOnlyBlockStore(dataDir string) (BlockStore, error) {
+	if DetectBackend(dataDir) == BackendLevelDb {
+		return openReadOnlyLevelDbBlockStore(dataDir)
+	}
+
+	f, err := os.Open(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	bs := &readOnlyBlockStore{byHash: make(map[Hash]Block), byHeight: make(map[uint64]Hash), corruptedAt: -1}
+	validEnd, err := scanBlockRecords(f, 0, func(blockFs BlockFs, offset int64, length uint32) error {
+		bs.blocks = append(bs.blocks, blockFs)
+		bs.byHash[blockFs.Key] = blockFs.Value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if validEnd != stat.Size() {
+		bs.corruptedAt = validEnd
+	}
+
+	bs.loadHead(getBlocksHeadFilePath(dataDir))
+
+	return bs, nil
+}
+
+// loadHead walks the canonical chain back from block.head, or from the
+// highest block when block.head is missing or points past the readable records.
+func (bs *readOnlyBlockStore) loadHead(headPath string) {
+	var head Hash
+
+	content, err := ioutil.ReadFile(headPath)
+	if err == nil {
+		err = head.UnmarshalText(bytes.TrimSpace(content))
+	}
+
+	if _, ok := bs.byHash[head]; err != nil || !ok {
+		found := false
+		for _, blockFs := range bs.blocks {
+			if !found || blockFs.Value.Header.Number > bs.byHash[head].Header.Number {
+				head, found = blockFs.Key, true
+			}
+		}
+
+		if !found {
+			return
+		}
+	}
+
+	for hash := head; !hash.IsEmpty(); {
+		b, ok := bs.byHash[hash]
+		if !ok {
+			return
+		}
+
+		bs.byHeight[b.Header.Number] = hash
+		hash = b.Header.Parent
+	}
+}
+
+func (bs *readOnlyBlockStore) Append(blockFs BlockFs) error {
+	return fmt.Errorf("block store is read-only")
+}
+
+func (bs *readOnlyBlockStore) SetHead(head Hash) error {
+	return fmt.Errorf("block store is read-only")
+}
+
+func (bs *readOnlyBlockStore) Iterate(fn func(blockFs BlockFs) error) error {
+	for _, blockFs := range bs.blocks {
+		if err := fn(blockFs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (bs *readOnlyBlockStore) GetByHash(hash Hash) (Block, error) {
+	b, ok := bs.byHash[hash]
+	if !ok {
+		return Block{}, fmt.Errorf("%w: hash '%s'", ErrBlockNotFound, hash.Hex())
+	}
+
+	return b, nil
+}
+
+func (bs *readOnlyBlockStore) GetByHeight(number uint64) (Block, error) {
+	hash, ok := bs.byHeight[number]
+	if !ok {
+		return Block{}, fmt.Errorf("%w: number '%d'", ErrBlockNotFound, number)
+	}
+
+	return bs.GetByHash(hash)
+}
+
+func (bs *readOnlyBlockStore) Close() error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"bufio"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"os"
+)
+
+type DroppedRecord struct {
+	Offset int64
+	Length int
+	Block  *BlockFs
+}
+
+type RepairReport struct {
+	Backend     string
+	ValidBlocks int
+	Dropped     []DroppedRecord
+}
+
+// RepairBlocksDb truncates the blocks database of the data dir to its last
+// valid block and reports every record that had to be dropped to do so.
+// Data dirs older than SchemaVersion are repaired too, as migrating them
+// needs a sound block.db, but not ones written by a newer build.
+func RepairBlocksDb(dataDir string) (RepairReport, error) {
+	if _, err := PendingMigrations(dataDir); err != nil {
+		return RepairReport{}, err
+	}
+
+	backend := DetectBackend(dataDir)
+	if backend == BackendLevelDb {
+		db, err := leveldb.RecoverFile(getBlocksLevelDbDirPath(dataDir), nil)
+		if err != nil {
+			return RepairReport{}, err
+		}
+
+		return RepairReport{Backend: backend}, db.Close()
+	}
+
+	report := RepairReport{Backend: backend}
+
+	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_RDWR, 0600)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer f.Close()
+
+	validEnd, err := scanBlockRecords(f, 0, func(blockFs BlockFs, offset int64, length uint32) error {
+		report.ValidBlocks++
+		return nil
+	})
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	if validEnd == stat.Size() {
+		return report, nil
+	}
+
+	offset := validEnd
+	reader := bufio.NewReader(io.NewSectionReader(f, validEnd, stat.Size()-validEnd))
+	for {
+		record, err := reader.ReadBytes('\n')
+		if len(record) > 0 {
+			dropped := DroppedRecord{Offset: offset, Length: len(record)}
+			if blockFs, err := decodeBlockRecord(record); err == nil {
+				dropped.Block = &blockFs
+			}
+
+			report.Dropped = append(report.Dropped, dropped)
+			offset += int64(len(record))
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return RepairReport{}, err
+		}
+	}
+
+	if err := f.Truncate(validEnd); err != nil {
+		return RepairReport{}, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return RepairReport{}, err
+	}
+
+	if err := os.Remove(getBlocksIndexFilePath(dataDir)); err != nil && !os.IsNotExist(err) {
+		return RepairReport{}, err
+	}
+
+	return report, nil
+}
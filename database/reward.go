@@ -0,0 +1,75 @@
+package database
+
+// DefaultBlockReward is the reward of a chain whose genesis doesn't set one.
+const DefaultBlockReward = 100
+
+// ChainInfo sums up the chain params and the current issuance of a chain.
+type ChainInfo struct {
+	ChainId           string `json:"chain_id"`
+	BlockHash         Hash   `json:"block_hash"`
+	BlockNumber       uint64 `json:"block_number"`
+	BlockReward       uint   `json:"block_reward"`
+	HalvingInterval   uint64 `json:"halving_interval"`
+	CirculatingSupply uint   `json:"circulating_supply"`
+	MaxSupply         uint   `json:"max_supply"`
+	Difficulty        uint64 `json:"difficulty"`
+	TargetBlockTime   uint64 `json:"target_block_time"`
+}
+
+// CirculatingSupply returns the TUB held by all accounts, the genesis
+// balances plus every reward minted since.
+func (s *State) CirculatingSupply() uint {
+	supply := uint(0)
+	for _, balance := range s.Balances {
+		supply += balance
+	}
+
+	return supply
+}
+
+// BlockReward returns the reward minted by block number, halved every
+// HalvingInterval blocks and cut so the supply never exceeds MaxSupply.
+func (s *State) BlockReward(number uint64) uint {
+	reward := s.genesis.InitialReward()
+
+	if s.genesis.HalvingInterval > 0 {
+		halvings := number / s.genesis.HalvingInterval
+		if halvings >= 64 {
+			return 0
+		}
+		reward >>= halvings
+	}
+
+	if s.genesis.MaxSupply > 0 {
+		supply := s.CirculatingSupply()
+		if supply >= s.genesis.MaxSupply {
+			return 0
+		}
+
+		if reward > s.genesis.MaxSupply-supply {
+			reward = s.genesis.MaxSupply - supply
+		}
+	}
+
+	return reward
+}
+
+// ChainInfo returns the chain params and the reward of the next block.
+func (s *State) ChainInfo() (ChainInfo, error) {
+	difficulty, err := s.NextDifficulty(s.latestBlockHash)
+	if err != nil {
+		return ChainInfo{}, err
+	}
+
+	return ChainInfo{
+		ChainId:           s.genesis.ChainId,
+		BlockHash:         s.latestBlockHash,
+		BlockNumber:       s.latestBlock.Header.Number,
+		BlockReward:       s.BlockReward(s.NextBlockNumber()),
+		HalvingInterval:   s.genesis.HalvingInterval,
+		CirculatingSupply: s.CirculatingSupply(),
+		MaxSupply:         s.genesis.MaxSupply,
+		Difficulty:        difficulty,
+		TargetBlockTime:   s.genesis.BlockTime(),
+	}, nil
+}
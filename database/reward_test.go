@@ -0,0 +1,45 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"testing"
+)
+
+func TestBlockReward_Halvings(t *testing.T) {
+	s := createTestState()
+	s.genesis = Genesis{BlockReward: 100, HalvingInterval: 10}
+
+	cases := map[uint64]uint{0: 100, 9: 100, 10: 50, 25: 25, 69: 1, 70: 0, 10000: 0}
+	for number, expected := range cases {
+		if reward := s.BlockReward(number); reward != expected {
+			t.Errorf("reward of block %d should be %d, got %d", number, expected, reward)
+		}
+	}
+}
+
+func TestBlockReward_MaxSupply(t *testing.T) {
+	acc := NewAccount("0x01")
+	s := createTestState(AccountState{acc, 950, 0})
+	s.genesis = Genesis{BlockReward: 100, MaxSupply: 1000}
+
+	if reward := s.BlockReward(0); reward != 50 {
+		t.Fatalf("reward should be cut to the 50 TUB left below the max supply, got %d", reward)
+	}
+
+	if err := applyBlockTxs(0, 0, []SignedTx{}, acc, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.CirculatingSupply() != 1000 {
+		t.Fatalf("circulating supply should reach the max supply of 1000, got %d", s.CirculatingSupply())
+	}
+
+	if reward := s.BlockReward(1); reward != 0 {
+		t.Fatalf("nothing should be minted past the max supply, got %d", reward)
+	}
+
+	genesis := Genesis{ChainId: "bar", GenesisTime: "2020-11-12T00:00:00Z", Balances: map[common.Address]uint{acc: 2000}, MaxSupply: 1000}
+	if err := genesis.Validate(); err == nil {
+		t.Fatal("genesis balances above the max supply should be invalid")
+	}
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const snapshotInterval = 100
+const snapshotsToKeep = 2
+// Version 2 snapshots hold the asset balances, version 3 ones the multisig
+// policies and version 4 ones the total work of the chain. Older ones were
+// taken before those existed and are still read.
+const snapshotVersion = 4
+const snapshotFileExt = ".json"
+
+type stateSnapshot struct {
+	Version         int                     `json:"version"`
+	GenesisHash     Hash                    `json:"genesis_hash"`
+	Balances        map[common.Address]uint `json:"balances"`
+	AssetBalances   map[string]map[common.Address]uint `json:"asset_balances"`
+	Multisigs       map[common.Address]MultisigPolicy  `json:"multisigs"`
+	Account2Nonce   map[common.Address]uint `json:"account2nonce"`
+	LatestBlock     Block                   `json:"latest_block"`
+	LatestBlockHash Hash                    `json:"latest_block_hash"`
+	TotalWork       *big.Int                `json:"total_work"`
+}
+
+type snapshotFs struct {
+	Checksum Hash            `json:"checksum"`
+	State    json.RawMessage `json:"state"`
+}
+
+func genesisFileHash(dataDir string) (Hash, error) {
+	content, err := ioutil.ReadFile(getGenesisJsonFilePath(dataDir))
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return sha256.Sum256(content), nil
+}
+
+// Snapshot persists the current balances, nonces and latest block so the next
+// start only has to replay the blocks added afterwards.
+func (s *State) Snapshot() (string, error) {
+	if !s.hasGenesisBlock {
+		return "", fmt.Errorf("there are no blocks to snapshot yet")
+	}
+
+	genesisHash, err := genesisFileHash(s.dataDir)
+	if err != nil {
+		return "", err
+	}
+
+	// The total work of an untracked head is computed back when loading.
+	var totalWork *big.Int
+	if node, ok := s.tree[s.latestBlockHash]; ok {
+		totalWork = node.totalWork
+	}
+
+	stateJson, err := json.Marshal(stateSnapshot{
+		Version:         snapshotVersion,
+		GenesisHash:     genesisHash,
+		Balances:        s.Balances,
+		AssetBalances:   s.AssetBalances,
+		Multisigs:       s.Multisigs,
+		Account2Nonce:   s.Account2Nonce,
+		LatestBlock:     s.latestBlock,
+		LatestBlockHash: s.latestBlockHash,
+		TotalWork:       totalWork,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	snapshotJson, err := json.Marshal(snapshotFs{sha256.Sum256(stateJson), stateJson})
+	if err != nil {
+		return "", err
+	}
+
+	dir := getSnapshotsDirPath(s.dataDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", s.latestBlock.Header.Number, snapshotFileExt))
+	if err := writeFileAtomically(path, snapshotJson); err != nil {
+		return "", err
+	}
+
+	if err := pruneSnapshots(dir); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+func writeFileAtomically(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// listSnapshots returns the snapshot files of dir, newest first.
+func listSnapshots(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	paths := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), snapshotFileExt) {
+			paths = append(paths, filepath.Join(dir, f.Name()))
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	return paths, nil
+}
+
+func pruneSnapshots(dir string) error {
+	paths, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+
+	for i := snapshotsToKeep; i < len(paths); i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadSnapshot(path string, genesisHash Hash, store BlockStore) (stateSnapshot, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return stateSnapshot{}, err
+	}
+
+	var snapshot snapshotFs
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return stateSnapshot{}, err
+	}
+
+	if sha256.Sum256(snapshot.State) != snapshot.Checksum {
+		return stateSnapshot{}, fmt.Errorf("checksum mismatch")
+	}
+
+	var state stateSnapshot
+	if err := json.Unmarshal(snapshot.State, &state); err != nil {
+		return stateSnapshot{}, err
+	}
+
+	if state.Version < 1 || state.Version > snapshotVersion {
+		return stateSnapshot{}, fmt.Errorf("unsupported version %d", state.Version)
+	}
+
+	if state.GenesisHash != genesisHash {
+		return stateSnapshot{}, fmt.Errorf("taken with a different genesis")
+	}
+
+	b, err := store.GetByHeight(state.LatestBlock.Header.Number)
+	if err != nil {
+		return stateSnapshot{}, err
+	}
+
+	hash, err := b.Hash()
+	if err != nil {
+		return stateSnapshot{}, err
+	}
+
+	if hash != state.LatestBlockHash {
+		return stateSnapshot{}, fmt.Errorf("block %d is no longer '%s'", state.LatestBlock.Header.Number, state.LatestBlockHash.Hex())
+	}
+
+	if state.Balances == nil {
+		state.Balances = make(map[common.Address]uint)
+	}
+
+	if state.Account2Nonce == nil {
+		state.Account2Nonce = make(map[common.Address]uint)
+	}
+
+	if state.Multisigs == nil {
+		state.Multisigs = make(map[common.Address]MultisigPolicy)
+	}
+
+	if state.TotalWork == nil {
+		state.TotalWork, err = canonicalWork(store, state.LatestBlock.Header.Number)
+		if err != nil {
+			return stateSnapshot{}, err
+		}
+	}
+
+	return state, nil
+}
+
+// loadLatestSnapshot returns the newest snapshot of the data dir taken at or
+// below block maxNumber that is intact and still matches the canonical chain.
+func loadLatestSnapshot(dataDir string, store BlockStore, maxNumber uint64) (stateSnapshot, bool) {
+	genesisHash, err := genesisFileHash(dataDir)
+	if err != nil {
+		return stateSnapshot{}, false
+	}
+
+	paths, err := listSnapshots(getSnapshotsDirPath(dataDir))
+	if err != nil {
+		fmt.Printf("WARNING: unable to list state snapshots. %s\n", err)
+		return stateSnapshot{}, false
+	}
+
+	for _, path := range paths {
+		snapshot, err := loadSnapshot(path, genesisHash, store)
+		if err != nil {
+			fmt.Printf("WARNING: ignoring state snapshot '%s'. %s\n", path, err)
+			continue
+		}
+
+		if snapshot.LatestBlock.Header.Number > maxNumber {
+			continue
+		}
+
+		return snapshot, true
+	}
+
+	return stateSnapshot{}, false
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestSnapshot_LoadsLatestValidSnapshot(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	store, err := openBlockStore(dataDir, BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	blocks := createTestBlocks(t, 2)
+	for _, blockFs := range blocks {
+		if err := store.Append(blockFs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.SetHead(blocks[1].Key); err != nil {
+		t.Fatal(err)
+	}
+
+	acc := NewAccount("0x01")
+	state := &State{
+		Balances:      map[common.Address]uint{},
+		Account2Nonce: map[common.Address]uint{},
+		store:         store,
+		dataDir:       dataDir,
+	}
+
+	snapshots := make([]string, 0)
+	for i, blockFs := range blocks {
+		state.Balances[acc] = uint(i + 1)
+		state.Account2Nonce[acc] = uint(i + 1)
+		state.latestBlock = blockFs.Value
+		state.latestBlockHash = blockFs.Key
+		state.hasGenesisBlock = true
+
+		path, err := state.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, path)
+	}
+
+	snapshot, ok := loadLatestSnapshot(dataDir, store, math.MaxUint64)
+	if !ok {
+		t.Fatal("snapshot should have been loaded")
+	}
+
+	if snapshot.LatestBlockHash != blocks[1].Key || snapshot.Balances[acc] != 2 || snapshot.Account2Nonce[acc] != 2 {
+		t.Fatalf("expected the snapshot of block 1, got block %d", snapshot.LatestBlock.Header.Number)
+	}
+
+	content, err := ioutil.ReadFile(snapshots[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-3] ^= 1
+
+	if err := ioutil.WriteFile(snapshots[1], content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, ok = loadLatestSnapshot(dataDir, store, math.MaxUint64)
+	if !ok {
+		t.Fatal("older snapshot should have been loaded")
+	}
+
+	if snapshot.LatestBlockHash != blocks[0].Key {
+		t.Fatalf("corrupted snapshot should fall back to block 0, got block %d", snapshot.LatestBlock.Header.Number)
+	}
+}
@@ -1,22 +1,37 @@
 package database
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
-	"os"
+	"math"
 	"reflect"
-	"sort"
+	"sync"
+	"time"
 )
 
+// MaxLockHeightDistance is how many blocks past the next one a pending tx can
+// be locked until. Nodes mine empty blocks to reach the lock height of their
+// pending txs, a farther lock would keep them doing so for nothing.
+const MaxLockHeightDistance = 1000
+
 type State struct {
 	Balances        map[common.Address]uint
+	AssetBalances   map[string]map[common.Address]uint
+	Multisigs       map[common.Address]MultisigPolicy
 	Account2Nonce map[common.Address]uint
-	DbFile          *os.File
+	store           BlockStore
+	dataDir         string
+	genesis         Genesis
 	latestBlock     Block
 	latestBlockHash Hash
 	hasGenesisBlock bool
+	tree            map[Hash]*blockTreeNode
+	events          *eventHub
+	queuedEvents    []Event
+	journal         *journal
+	accountIndex    *accountIndex
+	mu              sync.Mutex
 }
 
 func (s *State) LatestBlockHash() Hash {
@@ -39,74 +54,183 @@ func (s *State) GetNextAccountNonce(account common.Address) uint {
 	return s.Account2Nonce[account] + 1
 }
 
+func (s *State) ChainId() string {
+	return s.genesis.ChainId
+}
+
+// ValidateTxChain rejects txs signed for another chain, so they can't be
+// replayed across chains sharing accounts.
+func (s *State) ValidateTxChain(tx SignedTx) error {
+	if tx.ChainId != s.genesis.ChainId {
+		return fmt.Errorf("wrong TX. It is signed for chain '%s', not '%s'", tx.ChainId, s.genesis.ChainId)
+	}
+
+	return nil
+}
+
+// ValidatePendingTx rejects txs that can never be mined on top of the
+// canonical head: signed for another chain, over the block limits, of an
+// invalid kind, locked too far past the head or with a nonce already used by
+// their sender.
+func (s *State) ValidatePendingTx(tx SignedTx) error {
+	if err := s.ValidateTxChain(tx); err != nil {
+		return err
+	}
+
+	if err := s.BlockLimits().validateTx(tx); err != nil {
+		return err
+	}
+
+	if err := tx.validateKind(); err != nil {
+		return err
+	}
+
+	if tx.LockHeight > s.NextBlockNumber()+MaxLockHeightDistance {
+		return fmt.Errorf("wrong TX. Sender '%s' TX is locked until block %d, more than %d blocks past the next block %d", tx.From.String(), tx.LockHeight, MaxLockHeightDistance, s.NextBlockNumber())
+	}
+
+	if tx.Nonce < s.GetNextAccountNonce(tx.From) {
+		return fmt.Errorf("wrong TX. Sender '%s' nonce '%d' is already used, next nonce is '%d'", tx.From.String(), tx.Nonce, s.GetNextAccountNonce(tx.From))
+	}
+
+	return nil
+}
+
 func NewStateFromDisk(dataDir string) (*State, error) {
+	return NewStateFromDiskWithBackend(dataDir, DetectBackend(dataDir))
+}
+
+func NewStateFromDiskWithBackend(dataDir string, backend string) (*State, error) {
 	err := InitDataDir(dataDir, []byte(genesisJson))
 	if err != nil {
 		return nil, err
 	}
 
+	err = checkDataDirVersion(dataDir, false)
+	if err != nil {
+		return nil, err
+	}
+
 	genesis, err := loadGenesis(getGenesisJsonFilePath(dataDir))
 	if err != nil {
 		return nil, err
 	}
 	account2nonce := make(map[common.Address]uint)
 
-	balances := genesis.Balances
-	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_APPEND|os.O_RDWR, 0600)
+	balances := genesis.copyBalances()
+	store, err := openBlockStore(dataDir, backend)
 	if err != nil {
 		return nil, err
 	}
-	scanner := bufio.NewScanner(f)
 
-	state := &State{balances, account2nonce,f, Block{}, Hash{}, false}
+	state := &State{
+		Balances:      balances,
+		AssetBalances: genesis.copyAssetBalances(),
+		Multisigs:     make(map[common.Address]MultisigPolicy),
+		Account2Nonce: account2nonce,
+		store:         store,
+		dataDir:       dataDir,
+		genesis:       genesis,
+		tree:          make(map[Hash]*blockTreeNode),
+		events:        newEventHub(),
+	}
 
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	from := uint64(0)
+	if snapshot, ok := loadLatestSnapshot(dataDir, store, math.MaxUint64); ok {
+		state.Balances = snapshot.Balances
+		if snapshot.AssetBalances != nil {
+			state.AssetBalances = snapshot.AssetBalances
 		}
+		state.Multisigs = snapshot.Multisigs
+		state.Account2Nonce = snapshot.Account2Nonce
+		state.latestBlock = snapshot.LatestBlock
+		state.latestBlockHash = snapshot.LatestBlockHash
+		state.hasGenesisBlock = true
+		state.tree[snapshot.LatestBlockHash] = &blockTreeNode{snapshot.LatestBlock.Header.Parent, snapshot.LatestBlock.Header.Number, snapshot.TotalWork}
+		from = snapshot.LatestBlock.Header.Number + 1
 
-		blockFsJson := scanner.Bytes()
+		fmt.Printf("Loaded state snapshot at block %d, replaying the following blocks\n", snapshot.LatestBlock.Header.Number)
+	}
 
-		if len(blockFsJson) == 0 {
-			break
-		}
+	err = state.replayCanonicalBlocks(from, math.MaxUint64)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-		var blockFs BlockFs
-		err = json.Unmarshal(blockFsJson, &blockFs)
+	err = state.trackSideBlocks()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-		if err := applyBlock(blockFs.Value, state); err != nil {
-			return nil, err
-		}
-		state.latestBlockHash = blockFs.Key
-		state.latestBlock = blockFs.Value
-		state.hasGenesisBlock = true
+	state.accountIndex, err = openAccountIndex(getAccountIndexFilePath(dataDir))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	err = state.syncAccountIndex()
+	if err != nil {
+		state.Close()
+		return nil, err
 	}
+
 	return state, nil
 }
 
-func applyTXs(txs []SignedTx, s *State) error {
-	sort.Slice(txs, func(i, j int) bool {
-		return txs[i].Time < txs[j].Time
-	})
+func (s *State) replayBlock(blockFs BlockFs) error {
+	if err := applyBlock(blockFs.Value, s); err != nil {
+		return err
+	}
+	s.setHead(blockFs.Key, blockFs.Value)
 
-	for _, tx := range txs {
-		err := applyTx(tx, s)
+	if s.tree != nil {
+		s.trackBlock(blockFs.Key, blockFs.Value.Header)
+	}
+
+	return nil
+}
+
+// applyTXs applies txs in their block order, so the txs of a sender must
+// follow each other by nonce.
+func applyTXs(number uint64, blockTime uint64, txs []SignedTx, s *State) error {
+	for i, tx := range txs {
+		err := applyTx(tx, number, blockTime, s)
 		if err != nil {
-			return err
+			return fmt.Errorf("TX %d of the block is invalid. %s", i, err.Error())
 		}
 	}
 
 	return nil
 }
 
-func applyTx(tx SignedTx, s *State) error {
-	ok, err := tx.IsAuthentic()
-	if err != nil {
+// applyTx applies tx as part of block number, timed blockTime.
+func applyTx(tx SignedTx, number uint64, blockTime uint64, s *State) error {
+	if err := s.ValidateTxChain(tx); err != nil {
+		return err
+	}
+
+	if err := tx.validateKind(); err != nil {
+		return err
+	}
+
+	if !tx.IsMatureAt(number, blockTime) {
+		return fmt.Errorf("wrong TX. Sender '%s' TX is locked until block %d and time %d, not included before", tx.From.String(), tx.LockHeight, tx.LockTime)
+	}
+
+	if err := s.BlockLimits().validateTx(tx); err != nil {
 		return err
 	}
 
-	if !ok {
-		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
+	if err := s.ValidateTxSigs(tx); err != nil {
+		return err
+	}
+
+	if tx.IsMultisigRegistration() {
+		if err := tx.MultisigPolicy().validate(); err != nil {
+			return fmt.Errorf("wrong TX. Sender '%s' %s", tx.From.String(), err.Error())
+		}
 	}
 
 	expectedNonce := s.GetNextAccountNonce(tx.From)
@@ -114,29 +238,79 @@ func applyTx(tx SignedTx, s *State) error {
 		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
 	}
 
-	if s.Balances[tx.From] < tx.Value {
-		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TUB. Tx cost is %d TUB", tx.From.String(), s.Balances[tx.From], tx.Value)
+	if tx.IsNative() && tx.Cost() < tx.Value {
+		return fmt.Errorf("wrong TX. Sender '%s' value %d TUB plus fee %d TUB overflows", tx.From.String(), tx.Value, tx.Fee)
 	}
 
-	s.Balances[tx.From] -= tx.Value
-	s.Balances[tx.To] += tx.Value
+	if s.Balances[tx.From] < tx.Cost() {
+		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TUB. Tx cost is %d TUB", tx.From.String(), s.Balances[tx.From], tx.Cost())
+	}
 
-	s.Account2Nonce[tx.From] = tx.Nonce
+	if !tx.IsNative() {
+		if err := applyAssetTx(tx, s); err != nil {
+			return err
+		}
+	}
+
+	s.setBalance(tx.From, s.Balances[tx.From]-tx.Cost())
+	if tx.IsNative() {
+		s.setBalance(tx.To, s.Balances[tx.To]+tx.Value)
+	}
+
+	s.setNonce(tx.From, tx.Nonce)
+
+	if tx.IsMultisigRegistration() {
+		s.setMultisig(tx.From, tx.MultisigPolicy())
+	}
 
 	return nil
 }
 
+// AddBlock validates and persists a block. Blocks extending the head are
+// applied right away, blocks of other branches are kept and trigger a
+// reorganization once their branch has more work than the canonical one.
 func (s *State) AddBlock(b Block) (Hash, error) {
-	pendingState := s.copy()
+	s.mu.Lock()
+	hash, err := s.addBlock(b)
+	s.publishEvents()
+	s.mu.Unlock()
 
-	err := applyBlock(b, &pendingState)
+	return hash, err
+}
+
+func (s *State) addBlock(b Block) (Hash, error) {
+	blockHash, err := b.Hash()
 	if err != nil {
 		return Hash{}, err
 	}
-	blockHash, err := b.Hash()
+
+	if _, isKnown := s.tree[blockHash]; isKnown {
+		return blockHash, nil
+	}
+
+	parent, isParentKnown := s.tree[b.Header.Parent]
+	if b.Header.Parent.IsEmpty() {
+		if s.hasGenesisBlock {
+			return Hash{}, fmt.Errorf("block '%s' without parent conflicts with the existing genesis block", blockHash.Hex())
+		}
+	} else if !isParentKnown {
+		return Hash{}, fmt.Errorf("parent '%s' of block '%s' is unknown", b.Header.Parent.Hex(), blockHash.Hex())
+	} else if b.Header.Number != parent.number+1 {
+		return Hash{}, fmt.Errorf("block with parent number '%d' must be '%d' not '%d'", parent.number, parent.number+1, b.Header.Number)
+	}
+
+	if b.Header.Parent != s.latestBlockHash {
+		return s.addSideBlock(blockHash, b)
+	}
+
+	pendingState := s.overlay()
+	cp := pendingState.checkpoint()
+
+	err = applyBlock(b, pendingState)
 	if err != nil {
 		return Hash{}, err
 	}
+	pendingState.setHead(blockHash, b)
 
 	blockFs := BlockFs{blockHash, b}
 
@@ -148,26 +322,59 @@ func (s *State) AddBlock(b Block) (Hash, error) {
 	fmt.Printf("Persisting new block to disk:\n")
 	fmt.Printf("\t%s\n", blockFsJsonStr)
 
-	if _, err := s.DbFile.Write(append(blockFsJsonStr, '\n')); err != nil {
+	if err := s.store.Append(blockFs); err != nil {
+		return Hash{}, err
+	}
+
+	if err := s.store.SetHead(blockHash); err != nil {
 		return Hash{}, err
 	}
 
-	s.Balances = pendingState.Balances
-	s.latestBlockHash = blockHash
-	s.latestBlock = b
-	s.hasGenesisBlock = true
+	s.trackBlock(blockHash, b.Header)
+
+	s.queuedEvents = append(s.queuedEvents, BlockAdded{blockHash, b})
+	s.queuedEvents = append(s.queuedEvents, balanceChanges(s, pendingState, pendingState.changedAccounts(), blockHash)...)
+
+	pendingState.commitCheckpoint(cp)
+	s.commit(pendingState)
+
+	if s.accountIndex != nil {
+		if err := s.accountIndex.add(blockHash, b); err != nil {
+			fmt.Printf("WARNING: unable to index the txs of block %d. %s\n", b.Header.Number, err)
+		}
+	}
+
+	if b.Header.Number > 0 && b.Header.Number%snapshotInterval == 0 {
+		if _, err := s.Snapshot(); err != nil {
+			fmt.Printf("WARNING: unable to snapshot state at block %d. %s\n", b.Header.Number, err)
+		}
+	}
 
 	return s.latestBlockHash, nil
 }
 
+// applyBlock validates b and applies it to s. A block failing part way
+// through leaves s as it was.
 func applyBlock(b Block, s *State) error {
+	cp := s.checkpoint()
+
+	if err := applyBlockChanges(b, s); err != nil {
+		s.revertToCheckpoint(cp)
+		return err
+	}
+	s.commitCheckpoint(cp)
+
+	return nil
+}
+
+func applyBlockChanges(b Block, s *State) error {
 	nextExpectedBlockNumber := s.latestBlock.Header.Number + 1
 
 	if s.hasGenesisBlock && b.Header.Number != nextExpectedBlockNumber {
 		return fmt.Errorf("next expected block must be '%d' not '%d'", nextExpectedBlockNumber, b.Header.Number)
 	}
 
-	if s.hasGenesisBlock && s.latestBlock.Header.Number > 0 && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
+	if s.hasGenesisBlock && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
 		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
 	}
 
@@ -176,22 +383,139 @@ func applyBlock(b Block, s *State) error {
 		return err
 	}
 
-	if !IsBlockHashValid(hash) {
+	err = s.validateDifficulty(b)
+	if err != nil {
+		return err
+	}
+
+	err = s.validateBlockTime(b, uint64(time.Now().Unix()))
+	if err != nil {
+		return err
+	}
+
+	if !IsBlockHashValid(hash, b.Header.Difficulty) {
 		return fmt.Errorf("invalid block hash %x", hash)
 	}
 
-	err = applyTXs(b.Txs, s)
+	err = validateTxRoot(b)
+	if err != nil {
+		return err
+	}
+
+	err = s.BlockLimits().validateBlock(b)
+	if err != nil {
+		return err
+	}
+
+	err = applyBlockTxs(b.Header.Number, b.Header.Time, b.Txs, b.Header.Miner, s)
 	if err != nil {
 		return err
 	}
 
-	s.Balances[b.Header.Miner] += BlockReward
+	fees := TotalFees(b.Txs)
+	if fees != b.Header.Fees {
+		return fmt.Errorf("block fees must be '%d' not '%d'", fees, b.Header.Fees)
+	}
+
+	stateRoot := s.StateRoot()
+	if stateRoot != b.Header.StateRoot {
+		return fmt.Errorf("block state root must be '%s' not '%s'", stateRoot.Hex(), b.Header.StateRoot.Hex())
+	}
 
 	return nil
 }
 
+func applyBlockTxs(number uint64, blockTime uint64, txs []SignedTx, miner common.Address, s *State) error {
+	reward := s.BlockReward(number)
+
+	err := applyTXs(number, blockTime, txs, s)
+	if err != nil {
+		return err
+	}
+
+	s.setBalance(miner, s.Balances[miner]+reward+TotalFees(txs))
+
+	return nil
+}
+
+// PendingStateRoot returns the state root resulting from a block of txs
+// mined by miner on top of the latest block, timed blockTime.
+func (s *State) PendingStateRoot(txs []SignedTx, miner common.Address, blockTime uint64) (Hash, error) {
+	s.mu.Lock()
+	pendingState := s.overlay()
+	s.mu.Unlock()
+
+	err := applyBlockTxs(pendingState.NextBlockNumber(), blockTime, txs, miner, pendingState)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return pendingState.StateRoot(), nil
+}
+
+// TxRejection is a TX a dry run could not apply.
+type TxRejection struct {
+	Tx     SignedTx `json:"tx"`
+	Reason string   `json:"reason"`
+}
+
+// DryRun is the outcome of applying TXs on top of the latest block.
+type DryRun struct {
+	Applied   []SignedTx    `json:"applied"`
+	Rejected  []TxRejection `json:"rejected"`
+	Fees      uint          `json:"fees"`
+	StateRoot Hash          `json:"state_root"`
+}
+
+// DryRunTxs applies txs in order on top of the latest block, as the next block
+// mined by miner and timed blockTime would, without changing s. A TX failing
+// to apply is reverted and rejected along with the following TXs of its sender.
+func (s *State) DryRunTxs(txs []SignedTx, miner common.Address, blockTime uint64) DryRun {
+	s.mu.Lock()
+	pendingState := s.overlay()
+	s.mu.Unlock()
+
+	number := pendingState.NextBlockNumber()
+
+	result := DryRun{Applied: make([]SignedTx, 0), Rejected: make([]TxRejection, 0)}
+	failed := make(map[common.Address]bool)
+
+	for _, tx := range txs {
+		if failed[tx.From] {
+			result.Rejected = append(result.Rejected, TxRejection{tx, "an earlier TX of the sender was rejected"})
+			continue
+		}
+
+		cp := pendingState.checkpoint()
+		if err := applyTx(tx, number, blockTime, pendingState); err != nil {
+			pendingState.revertToCheckpoint(cp)
+			failed[tx.From] = true
+			result.Rejected = append(result.Rejected, TxRejection{tx, err.Error()})
+			continue
+		}
+		pendingState.commitCheckpoint(cp)
+
+		result.Applied = append(result.Applied, tx)
+	}
+
+	result.Fees = TotalFees(result.Applied)
+	reward := pendingState.BlockReward(number)
+	pendingState.setBalance(miner, pendingState.Balances[miner]+reward+result.Fees)
+	result.StateRoot = pendingState.StateRoot()
+
+	return result
+}
+
 func (s *State) Close() error {
-	return s.DbFile.Close()
+	if s.events != nil {
+		s.events.close()
+	}
+
+	if s.accountIndex != nil {
+		s.accountIndex.close()
+	}
+
+	return s.store.Close()
 }
 
 func (s *State) AddBlocks(blocks []Block) error {
@@ -204,17 +528,29 @@ func (s *State) AddBlocks(blocks []Block) error {
 	return nil
 }
 
-func (s *State) copy() State {
-	c := State{}
+// overlay returns a complete copy of the ledger of s. Changes are applied to
+// the overlay, away from readers of s, and made visible at once by commit.
+func (s *State) overlay() *State {
+	c := &State{}
+	c.store = s.store
+	c.dataDir = s.dataDir
+	c.genesis = s.genesis
 	c.hasGenesisBlock = s.hasGenesisBlock
 	c.latestBlock = s.latestBlock
 	c.latestBlockHash = s.latestBlockHash
 	c.Balances = make(map[common.Address]uint)
+	c.AssetBalances = copyAssetBalances(s.AssetBalances)
+	c.Multisigs = copyMultisigs(s.Multisigs)
+	c.Account2Nonce = make(map[common.Address]uint)
 
 	for acc, balance := range s.Balances {
 		c.Balances[acc] = balance
 	}
 
+	for acc, nonce := range s.Account2Nonce {
+		c.Account2Nonce[acc] = nonce
+	}
+
 	return c
 }
 
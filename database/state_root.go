@@ -0,0 +1,229 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"sort"
+)
+
+// The state root is the root of a sparse Merkle tree keyed by the bits of
+// account addresses. An empty subtree hashes to the empty Hash and a subtree
+// holding a single account hashes to the leaf of that account, so the tree
+// stays as shallow as needed to tell the accounts apart.
+//
+// Once an account holds an asset other than TUB, the state root is the
+// parent of that tree root and of the Merkle root of the asset balances.
+// Once a multisig is registered, it is in turn the parent of that root and of
+// the Merkle root of the multisig policies.
+
+const stateTreeDepth = common.AddressLength * 8
+
+type AccountState struct {
+	Account common.Address `json:"account"`
+	Balance uint           `json:"balance"`
+	Nonce   uint           `json:"nonce"`
+}
+
+// AccountProof proves the balance and nonce of an account, given only the
+// header of the block the state root is taken from. Siblings go from the
+// root down to the subtree holding the account. An account without balance
+// and nonce is proven absent by an empty subtree or by Other, the single
+// account whose subtree the account would belong to. AssetsRoot and
+// MultisigsRoot are set when the state root also commits to asset balances and
+// multisig policies.
+type AccountProof struct {
+	BlockHash Hash        `json:"block_hash"`
+	Header    BlockHeader `json:"header"`
+	AccountState
+	Siblings []Hash        `json:"siblings"`
+	Other    *AccountState `json:"other,omitempty"`
+	AssetsRoot *Hash       `json:"assets_root,omitempty"`
+	MultisigsRoot *Hash    `json:"multisigs_root,omitempty"`
+}
+
+func (a AccountState) isEmpty() bool {
+	return a.Balance == 0 && a.Nonce == 0
+}
+
+func (a AccountState) leaf() Hash {
+	content := make([]byte, 1+common.AddressLength+16)
+	content[0] = merkleLeafPrefix
+	copy(content[1:], a.Account[:])
+	binary.BigEndian.PutUint64(content[1+common.AddressLength:], uint64(a.Balance))
+	binary.BigEndian.PutUint64(content[1+common.AddressLength+8:], uint64(a.Nonce))
+
+	return sha256.Sum256(content)
+}
+
+func addressBit(account common.Address, depth int) bool {
+	return account[depth/8]&(0x80>>uint(depth%8)) != 0
+}
+
+// accountStates returns the non empty accounts of s sorted by address.
+func (s *State) accountStates() []AccountState {
+	accounts := make(map[common.Address]bool)
+	for acc := range s.Balances {
+		accounts[acc] = true
+	}
+	for acc := range s.Account2Nonce {
+		accounts[acc] = true
+	}
+
+	states := make([]AccountState, 0, len(accounts))
+	for acc := range accounts {
+		state := AccountState{acc, s.Balances[acc], s.Account2Nonce[acc]}
+		if !state.isEmpty() {
+			states = append(states, state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return bytes.Compare(states[i].Account[:], states[j].Account[:]) < 0
+	})
+
+	return states
+}
+
+// splitByBit splits accounts sorted by address into those with the bit at
+// depth unset and those with it set.
+func splitByBit(accounts []AccountState, depth int) ([]AccountState, []AccountState) {
+	i := sort.Search(len(accounts), func(i int) bool {
+		return addressBit(accounts[i].Account, depth)
+	})
+
+	return accounts[:i], accounts[i:]
+}
+
+func stateSubtreeRoot(accounts []AccountState, depth int) Hash {
+	switch len(accounts) {
+	case 0:
+		return Hash{}
+	case 1:
+		return accounts[0].leaf()
+	}
+
+	left, right := splitByBit(accounts, depth)
+
+	return merkleParent(stateSubtreeRoot(left, depth+1), stateSubtreeRoot(right, depth+1))
+}
+
+// StateRoot returns the root committing to the balances, in every asset,
+// nonces and multisig policies of every account.
+func (s *State) StateRoot() Hash {
+	root := stateSubtreeRoot(s.accountStates(), 0)
+
+	if assetsRoot, ok := s.assetsRoot(); ok {
+		root = merkleParent(root, assetsRoot)
+	}
+
+	if multisigsRoot, ok := s.multisigsRoot(); ok {
+		root = merkleParent(root, multisigsRoot)
+	}
+
+	return root
+}
+
+// GetAccountProof proves the balance and nonce of account at the latest block.
+func (s *State) GetAccountProof(account common.Address) (AccountProof, error) {
+	if !s.hasGenesisBlock {
+		return AccountProof{}, fmt.Errorf("there are no blocks to prove the state of yet")
+	}
+
+	proof := AccountProof{
+		BlockHash:    s.latestBlockHash,
+		Header:       s.latestBlock.Header,
+		AccountState: AccountState{account, s.Balances[account], s.Account2Nonce[account]},
+		Siblings:     make([]Hash, 0),
+	}
+
+	accounts := s.accountStates()
+	for depth := 0; len(accounts) > 1; depth++ {
+		left, right := splitByBit(accounts, depth)
+		if addressBit(account, depth) {
+			proof.Siblings = append(proof.Siblings, stateSubtreeRoot(left, depth+1))
+			accounts = right
+		} else {
+			proof.Siblings = append(proof.Siblings, stateSubtreeRoot(right, depth+1))
+			accounts = left
+		}
+	}
+
+	if len(accounts) == 1 && accounts[0].Account != account {
+		other := accounts[0]
+		proof.Other = &other
+	}
+
+	if assetsRoot, ok := s.assetsRoot(); ok {
+		proof.AssetsRoot = &assetsRoot
+	}
+
+	if multisigsRoot, ok := s.multisigsRoot(); ok {
+		proof.MultisigsRoot = &multisigsRoot
+	}
+
+	return proof, nil
+}
+
+// Verify checks the account state hashes up to the state root of the header
+// and that the header is the one of block BlockHash.
+func (p AccountProof) Verify() error {
+	blockHash, err := Block{Header: p.Header}.Hash()
+	if err != nil {
+		return err
+	}
+
+	if blockHash != p.BlockHash {
+		return fmt.Errorf("header hashes to '%s', not block '%s'", blockHash.Hex(), p.BlockHash.Hex())
+	}
+
+	if len(p.Siblings) > stateTreeDepth {
+		return fmt.Errorf("proof has %d siblings, the tree is only %d deep", len(p.Siblings), stateTreeDepth)
+	}
+
+	var node Hash
+	switch {
+	case p.Other != nil:
+		if !p.isEmpty() {
+			return fmt.Errorf("account '%s' with a balance or nonce can't be proven absent", p.Account.Hex())
+		}
+
+		if p.Other.Account == p.Account || p.Other.isEmpty() {
+			return fmt.Errorf("other account '%s' doesn't prove the absence of '%s'", p.Other.Account.Hex(), p.Account.Hex())
+		}
+
+		for depth := range p.Siblings {
+			if addressBit(p.Other.Account, depth) != addressBit(p.Account, depth) {
+				return fmt.Errorf("other account '%s' is not on the path of '%s'", p.Other.Account.Hex(), p.Account.Hex())
+			}
+		}
+
+		node = p.Other.leaf()
+	case !p.isEmpty():
+		node = p.leaf()
+	}
+
+	for depth := len(p.Siblings) - 1; depth >= 0; depth-- {
+		if addressBit(p.Account, depth) {
+			node = merkleParent(p.Siblings[depth], node)
+		} else {
+			node = merkleParent(node, p.Siblings[depth])
+		}
+	}
+
+	if p.AssetsRoot != nil {
+		node = merkleParent(node, *p.AssetsRoot)
+	}
+
+	if p.MultisigsRoot != nil {
+		node = merkleParent(node, *p.MultisigsRoot)
+	}
+
+	if node != p.Header.StateRoot {
+		return fmt.Errorf("proof leads to root '%s', not the block state root '%s'", node.Hex(), p.Header.StateRoot.Hex())
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"testing"
+)
+
+func createTestState(accounts ...AccountState) *State {
+	s := &State{Balances: make(map[common.Address]uint), Multisigs: make(map[common.Address]MultisigPolicy), Account2Nonce: make(map[common.Address]uint)}
+	for _, acc := range accounts {
+		s.Balances[acc.Account] = acc.Balance
+		s.Account2Nonce[acc.Account] = acc.Nonce
+	}
+
+	return s
+}
+
+func proveAccount(t *testing.T, s *State, account common.Address) AccountProof {
+	b, err := NewBlock(Hash{}, 0, 0, DefaultDifficulty, 0, NewAccount(""), s.StateRoot(), []SignedTx{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.latestBlock = b
+	s.latestBlockHash, err = b.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.hasGenesisBlock = true
+
+	proof, err := s.GetAccountProof(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return proof
+}
+
+func TestStateRoot_IgnoresEmptyAccounts(t *testing.T) {
+	s := createTestState(AccountState{NewAccount("0x01"), 10, 1})
+	root := s.StateRoot()
+
+	s.Balances[NewAccount("0x02")] = 0
+	if s.StateRoot() != root {
+		t.Fatal("accounts without balance and nonce should not change the state root")
+	}
+
+	s.Balances[NewAccount("0x01")] = 9
+	if s.StateRoot() == root {
+		t.Fatal("a balance change should change the state root")
+	}
+}
+
+func TestAccountProof(t *testing.T) {
+	s := createTestState(
+		AccountState{NewAccount("0x10"), 10, 1},
+		AccountState{NewAccount("0x11"), 20, 2},
+		AccountState{NewAccount("0x80000000000000000000000000000000000000"), 30, 0},
+		AccountState{NewAccount("0xf000000000000000000000000000000000000000"), 40, 4},
+	)
+
+	for _, acc := range []string{"0x10", "0x11", "0x80000000000000000000000000000000000000", "0xf000000000000000000000000000000000000000"} {
+		proof := proveAccount(t, s, NewAccount(acc))
+		if err := proof.Verify(); err != nil {
+			t.Fatalf("proof of account '%s' should be valid. %s", acc, err)
+		}
+
+		forged := proof
+		forged.Balance++
+		if err := forged.Verify(); err == nil {
+			t.Fatalf("proof of account '%s' with a forged balance should be invalid", acc)
+		}
+	}
+
+	for _, acc := range []string{"0x12", "0x4000000000000000000000000000000000000000", "0xff00000000000000000000000000000000000000"} {
+		proof := proveAccount(t, s, NewAccount(acc))
+		if err := proof.Verify(); err != nil {
+			t.Fatalf("absence proof of account '%s' should be valid. %s", acc, err)
+		}
+
+		forged := proof
+		forged.Balance = 1
+		forged.Other = nil
+		if err := forged.Verify(); err == nil {
+			t.Fatalf("proof of absent account '%s' with a forged balance should be invalid", acc)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A block must be newer than the median time of the medianTimeBlocks blocks
+// before it, and at most maxFutureBlockTime seconds ahead of the clock of
+// the node validating it.
+const medianTimeBlocks = 11
+const maxFutureBlockTime = 2 * 60 * 60
+
+// MedianTimePast returns the median time of parent and the blocks before it.
+// An empty parent stands for the first block of the chain.
+func (s *State) MedianTimePast(parent Hash) (uint64, error) {
+	times := make([]uint64, 0, medianTimeBlocks)
+	for hash := parent; !hash.IsEmpty() && len(times) < medianTimeBlocks; {
+		b, err := s.store.GetByHash(hash)
+		if err != nil {
+			return 0, err
+		}
+
+		times = append(times, b.Header.Time)
+		hash = b.Header.Parent
+	}
+
+	if len(times) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(times, func(i, j int) bool {
+		return times[i] < times[j]
+	})
+
+	return times[len(times)/2], nil
+}
+
+// NextBlockTime returns the time a block mined now on top of parent gets,
+// the current time unless the chain is ahead of the local clock.
+func (s *State) NextBlockTime(parent Hash) (uint64, error) {
+	median, err := s.MedianTimePast(parent)
+	if err != nil {
+		return 0, err
+	}
+
+	now := uint64(time.Now().Unix())
+	if now <= median {
+		return median + 1, nil
+	}
+
+	return now, nil
+}
+
+func (s *State) validateBlockTime(b Block, now uint64) error {
+	median, err := s.MedianTimePast(b.Header.Parent)
+	if err != nil {
+		return err
+	}
+
+	if b.Header.Time <= median {
+		return fmt.Errorf("block time %d must be after the median time %d of the previous blocks", b.Header.Time, median)
+	}
+
+	if b.Header.Time > now+maxFutureBlockTime {
+		return fmt.Errorf("block time %d is more than %d seconds in the future", b.Header.Time, maxFutureBlockTime)
+	}
+
+	for _, tx := range b.Txs {
+		if tx.Time > b.Header.Time {
+			return fmt.Errorf("wrong TX. Sender '%s' TX time %d is after the block time %d", tx.From.String(), tx.Time, b.Header.Time)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/crypto"
+	"os"
+	"testing"
+)
+
+func TestValidateBlockTime(t *testing.T) {
+	dataDir := setUpTestDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	store, err := openBlockStore(dataDir, BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// createTestBlocks gives block i the time i.
+	blocks := createTestBlocks(t, medianTimeBlocks+1)
+	for _, blockFs := range blocks {
+		if err := store.Append(blockFs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &State{store: store}
+	parent := blocks[len(blocks)-1].Key
+
+	median, err := s.MedianTimePast(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if median != 6 {
+		t.Fatalf("median time of blocks 1 to 11 should be 6, got %d", median)
+	}
+
+	now := uint64(1000)
+	cases := map[uint64]bool{6: false, 7: true, now: true, now + maxFutureBlockTime: true, now + maxFutureBlockTime + 1: false}
+	for blockTime, valid := range cases {
+		b := Block{Header: BlockHeader{Parent: parent, Number: uint64(len(blocks)), Time: blockTime}}
+		if err := s.validateBlockTime(b, now); (err == nil) != valid {
+			t.Errorf("block time %d should be valid: %t, got %v", blockTime, valid, err)
+		}
+	}
+
+	tx := NewTx(NewAccount("0x2"), NewAccount("0x1"), 1, 0, 1, "")
+	tx.Time = 8
+	b := Block{Header: BlockHeader{Parent: parent, Time: 7}, Txs: []SignedTx{NewSignedTx(tx, nil)}}
+	if err := s.validateBlockTime(b, now); err == nil {
+		t.Fatal("a TX newer than its block should be invalid")
+	}
+}
+
+func signTestTx(t *testing.T, tx Tx, privKey *ecdsa.PrivateKey) SignedTx {
+	txJson, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := crypto.Sign(crypto.Keccak256(txJson), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSignedTx(tx, sig)
+}
+
+func TestApplyTXs_BlockOrder(t *testing.T) {
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(privKey.PublicKey)
+	s := createTestState(AccountState{sender, 100, 0})
+
+	first := NewTx(NewAccount("0x2"), sender, 1, 0, 1, "")
+	second := NewTx(NewAccount("0x2"), sender, 1, 0, 2, "")
+	second.Time = first.Time - 1
+
+	if err := applyTXs(0, 0, []SignedTx{signTestTx(t, second, privKey), signTestTx(t, first, privKey)}, s); err == nil {
+		t.Fatal("TXs of a sender out of nonce order should be invalid")
+	}
+
+	s = createTestState(AccountState{sender, 100, 0})
+	if err := applyTXs(0, 0, []SignedTx{signTestTx(t, first, privKey), signTestTx(t, second, privKey)}, s); err != nil {
+		t.Fatalf("TXs in nonce order should apply whatever their time. %s", err)
+	}
+}
+
+func TestApplyTx_TimeLock(t *testing.T) {
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(privKey.PublicKey)
+
+	tx := NewTx(NewAccount("0x2"), sender, 1, 0, 1, "rent")
+	tx.LockHeight = 10
+	tx.LockTime = 2000000000
+
+	raw, err := signTestTx(t, tx, privKey).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeSignedTx(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.LockHeight != tx.LockHeight || decoded.LockTime != tx.LockTime || decoded.Asset != "" {
+		t.Fatalf("decoded tx should keep its locks, got %+v", decoded.Tx)
+	}
+
+	cases := []struct {
+		number    uint64
+		blockTime uint64
+		valid     bool
+	}{{9, 2000000000, false}, {10, 1999999999, false}, {10, 2000000000, true}, {11, 2000000001, true}}
+	for _, c := range cases {
+		s := createTestState(AccountState{sender, 100, 0})
+		if err := applyTx(decoded, c.number, c.blockTime, s); (err == nil) != c.valid {
+			t.Errorf("tx locked until block 10 and time 2000000000 should be valid in block %d at %d: %t, got %v", c.number, c.blockTime, c.valid, err)
+		}
+	}
+}
+
+func TestValidatePendingTx(t *testing.T) {
+	sender := NewAccount("0x1")
+	s := createTestState(AccountState{sender, 100, 2})
+
+	newTx := func(nonce uint, chainId string, lockHeight uint64) SignedTx {
+		tx := NewTx(NewAccount("0x2"), sender, 1, 0, nonce, "")
+		tx.ChainId = chainId
+		tx.LockHeight = lockHeight
+
+		return NewSignedTx(tx, nil)
+	}
+
+	farLock := s.NextBlockNumber() + MaxLockHeightDistance
+	cases := []struct {
+		tx    SignedTx
+		valid bool
+	}{
+		{newTx(2, "", 10), false}, {newTx(1, "", 10), false}, {newTx(3, "other", 10), false}, {newTx(3, "", 10), true}, {newTx(5, "", 10), true},
+		{newTx(3, "", farLock), true}, {newTx(3, "", farLock+1), false},
+	}
+	for _, c := range cases {
+		if err := s.ValidatePendingTx(c.tx); (err == nil) != c.valid {
+			t.Errorf("pending tx of nonce %d for chain '%s' locked until block %d should be valid: %t, got %v", c.tx.Nonce, c.tx.ChainId, c.tx.LockHeight, c.valid, err)
+		}
+	}
+}
@@ -3,12 +3,26 @@ package database
 import (
 	"crypto/elliptic"
 	"crypto/sha256"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"time"
 )
 
+var ErrTxNotFound = errors.New("tx not found")
+
+// TxKind tells what a tx does with its Value. It is signed with the tx.
+type TxKind uint
+
+const (
+	// TxKindTransfer moves Value from the sender to the recipient.
+	TxKindTransfer TxKind = iota
+	// TxKindMint is the issuer of an asset creating Value new units for the
+	// recipient instead of transferring its own.
+	TxKindMint
+)
+
 func NewAccount(value string) common.Address {
 	return common.HexToAddress(value)
 }
@@ -18,22 +32,92 @@ type Tx struct {
 	From   common.Address `json:"from"`
 	Nonce uint `json:"nonce"`
 	Value  uint    `json:"value"`
+	Fee    uint    `json:"fee"`
 	Reason string  `json:"reason"`
 	Time   uint64  `json:"time"`
+	ChainId string `json:"chain_id"`
+	Asset  string  `json:"asset,omitempty"`
+	LockHeight uint64 `json:"lock_height,omitempty"`
+	LockTime   uint64 `json:"lock_time,omitempty"`
+	Threshold  uint             `json:"threshold,omitempty"`
+	Signers    []common.Address `json:"signers,omitempty"`
+	Kind       TxKind           `json:"kind,omitempty"`
 }
 
+// SignedTx carries the signature of its sender, or the cosignatures of the
+// signers of a multisig sender in Sigs.
 type SignedTx struct {
 	Tx
 	Sig []byte `json:"signature"`
+	Sigs [][]byte `json:"cosignatures,omitempty"`
 }
 
 
-func NewTx(to common.Address, from common.Address, value uint, nonce uint, reason string) Tx {
-	return Tx{to, from, value, nonce, reason, uint64(time.Now().Unix())}
+func NewTx(to common.Address, from common.Address, value uint, fee uint, nonce uint, reason string) Tx {
+	return Tx{To: to, From: from, Nonce: nonce, Value: value, Fee: fee, Reason: reason, Time: uint64(time.Now().Unix())}
 }
 
 func NewSignedTx(tx Tx, sig []byte) SignedTx {
-	return SignedTx{tx, sig}
+	return SignedTx{Tx: tx, Sig: sig}
+}
+
+// IsNative tells whether the tx transfers TUB rather than another asset.
+func (tx Tx) IsNative() bool {
+	return tx.Asset == ""
+}
+
+// AssetSymbol returns the asset transferred by the tx, NativeAsset for TUB.
+func (tx Tx) AssetSymbol() string {
+	if tx.IsNative() {
+		return NativeAsset
+	}
+
+	return tx.Asset
+}
+
+// IsMint tells whether the tx is its asset issuer creating new units.
+func (tx Tx) IsMint() bool {
+	return !tx.IsNative() && tx.Kind == TxKindMint
+}
+
+// validateKind rejects kinds this build doesn't know and mints of TUB, which
+// only block rewards create.
+func (tx Tx) validateKind() error {
+	if tx.Kind > TxKindMint {
+		return fmt.Errorf("wrong TX. Sender '%s' TX kind %d is unknown", tx.From.String(), tx.Kind)
+	}
+
+	if tx.Kind == TxKindMint && tx.IsNative() {
+		return fmt.Errorf("wrong TX. Sender '%s' can't mint %s", tx.From.String(), NativeAsset)
+	}
+
+	return nil
+}
+
+// IsMatureAt tells whether the tx can be included in block number, timed
+// blockTime. A tx is locked until the block LockHeight and until LockTime.
+func (tx Tx) IsMatureAt(number uint64, blockTime uint64) bool {
+	return number >= tx.LockHeight && blockTime >= tx.LockTime
+}
+
+// Cost is the TUB the sender pays, the fee earned by the miner plus the value
+// of a TUB transfer.
+func (tx Tx) Cost() uint {
+	if !tx.IsNative() {
+		return tx.Fee
+	}
+
+	return tx.Value + tx.Fee
+}
+
+// TotalFees returns the fees a miner earns by including txs.
+func TotalFees(txs []SignedTx) uint {
+	fees := uint(0)
+	for _, tx := range txs {
+		fees += tx.Fee
+	}
+
+	return fees
 }
 
 func (tx Tx) IsReward() bool {
@@ -49,26 +133,33 @@ func (tx Tx) Hash() (Hash, error) {
 	return sha256.Sum256(txJson), nil
 }
 
-func (tx Tx) Encode() ([]byte, error) {
-	return json.Marshal(tx)
-}
-
+// IsAuthentic tells whether the tx, including the chain it is bound to, was
+// signed by its sender.
 func (tx SignedTx) IsAuthentic() (bool,error) {
-	txHash, err := tx.Tx.Hash()
+	recoveredAccount, err := tx.Tx.signer(tx.Sig)
 	if err != nil {
 		return false, err
 	}
 
-	recoveredPubKey, err := crypto.SigToPub(txHash[:], tx.Sig)
+	return recoveredAccount.Hex() == tx.From.Hex(), nil
+}
+
+// signer recovers the account whose key made sig over the tx.
+func (tx Tx) signer(sig []byte) (common.Address, error) {
+	txJson, err := tx.Encode()
 	if err != nil {
-		return false, err
+		return common.Address{}, err
+	}
+
+	recoveredPubKey, err := crypto.SigToPub(crypto.Keccak256(txJson), sig)
+	if err != nil {
+		return common.Address{}, err
 	}
 
 	recoveredPubKeyBytes := elliptic.Marshal(crypto.S256(), recoveredPubKey.X, recoveredPubKey.Y)
 	recoveredPubKeyBytesHash := crypto.Keccak256(recoveredPubKeyBytes[1:])
-	recoveredAccount := common.BytesToAddress(recoveredPubKeyBytesHash[12:])
 
-	return recoveredAccount.Hex() == tx.From.Hex(), nil
+	return common.BytesToAddress(recoveredPubKeyBytesHash[12:]), nil
 }
 
 
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ChainVerification sums up a full verification of the canonical chain of a
+// data dir. Totals cover the blocks verified before Failure, if any.
+type ChainVerification struct {
+	ChainId      string
+	StoredBlocks int
+	Blocks       int
+	Txs          int
+	Transferred  uint
+	Fees         uint
+	Minted       uint
+	Accounts     int
+	Supply       uint
+	Head         Hash
+	HeadNumber   uint64
+	Failure      *BlockFailure
+}
+
+// BlockFailure is the first block of the canonical chain that is invalid.
+type BlockFailure struct {
+	Number uint64
+	Hash   Hash
+	Reason string
+}
+
+// VerifyChain replays the canonical chain of dataDir from the genesis,
+// without snapshots, re-checking every block and tx on the way. The data dir
+// is only read, a torn or corrupted block.db record is reported as the
+// Failure of the block it should hold rather than repaired. Only failing to
+// read the data dir is returned as an error, an invalid block is reported as
+// the Failure of the verification.
+func VerifyChain(dataDir string) (ChainVerification, error) {
+	if !fileExist(getGenesisJsonFilePath(dataDir)) {
+		return ChainVerification{}, fmt.Errorf("data dir '%s' has no genesis", dataDir)
+	}
+
+	genesis, err := loadGenesis(getGenesisJsonFilePath(dataDir))
+	if err != nil {
+		return ChainVerification{}, err
+	}
+
+	if err := genesis.Validate(); err != nil {
+		return ChainVerification{}, err
+	}
+
+	if err := checkDataDirVersion(dataDir, true); err != nil {
+		return ChainVerification{}, err
+	}
+
+	store, err := openReadOnlyBlockStore(dataDir)
+	if err != nil {
+		return ChainVerification{}, err
+	}
+	defer store.Close()
+
+	s := &State{Balances: genesis.copyBalances(), AssetBalances: genesis.copyAssetBalances(), Multisigs: make(map[common.Address]MultisigPolicy), Account2Nonce: make(map[common.Address]uint), store: store, dataDir: dataDir, genesis: genesis}
+	result := ChainVerification{ChainId: genesis.ChainId}
+	genesisSupply := s.CirculatingSupply()
+
+	err = store.Iterate(func(blockFs BlockFs) error {
+		result.StoredBlocks++
+		return nil
+	})
+	if err != nil {
+		return ChainVerification{}, err
+	}
+
+	number := uint64(0)
+	if _, err := store.GetByHeight(0); errors.Is(err, ErrBlockNotFound) {
+		// Chains created before blocks were numbered from 0 start at 1.
+		number = 1
+	}
+
+	for ; ; number++ {
+		b, err := store.GetByHeight(number)
+		if errors.Is(err, ErrBlockNotFound) {
+			break
+		}
+		if err != nil {
+			return ChainVerification{}, err
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			return ChainVerification{}, err
+		}
+
+		if err := s.verifyBlock(hash, b); err != nil {
+			result.Failure = &BlockFailure{number, hash, err.Error()}
+			break
+		}
+
+		s.setHead(hash, b)
+
+		result.Blocks++
+		result.Txs += len(b.Txs)
+		result.Fees += b.Header.Fees
+		for _, tx := range b.Txs {
+			if tx.IsNative() {
+				result.Transferred += tx.Value
+			}
+		}
+	}
+
+	if readOnly, ok := store.(*readOnlyBlockStore); ok && readOnly.corruptedAt >= 0 && result.Failure == nil {
+		number := uint64(0)
+		if s.hasGenesisBlock {
+			number = s.latestBlock.Header.Number + 1
+		}
+
+		result.Failure = &BlockFailure{Number: number, Reason: fmt.Sprintf("block.db record at offset %d is torn or corrupted, run 'tub db repair'", readOnly.corruptedAt)}
+	}
+
+	result.Supply = s.CirculatingSupply()
+	result.Minted = result.Supply - genesisSupply
+	result.Accounts = len(s.accountStates())
+	result.Head = s.latestBlockHash
+	result.HeadNumber = s.latestBlock.Header.Number
+
+	return result, nil
+}
+
+// verifyBlock checks b links to the latest verified block and applies it.
+func (s *State) verifyBlock(hash Hash, b Block) error {
+	if !s.hasGenesisBlock {
+		if !b.Header.Parent.IsEmpty() {
+			return fmt.Errorf("first block must have no parent, not '%s'", b.Header.Parent.Hex())
+		}
+	} else {
+		if b.Header.Parent != s.latestBlockHash {
+			return fmt.Errorf("parent must be block %d '%s', not '%s'", s.latestBlock.Header.Number, s.latestBlockHash.Hex(), b.Header.Parent.Hex())
+		}
+
+		if b.Header.Number != s.latestBlock.Header.Number+1 {
+			return fmt.Errorf("height must be %d, not %d", s.latestBlock.Header.Number+1, b.Header.Number)
+		}
+	}
+
+	return applyBlock(b, s)
+}
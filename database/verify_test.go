@@ -0,0 +1,123 @@
+package database
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+
+	for i := 0; i < 3; i++ {
+		mineTestBlock(t, s)
+	}
+	head := s.LatestBlockHash()
+	s.Close()
+
+	result, err := VerifyChain(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Failure != nil {
+		t.Fatalf("chain should be valid, got a failure at block %d. %s", result.Failure.Number, result.Failure.Reason)
+	}
+
+	if result.Blocks != 3 || result.Head != head || result.Minted != 3*DefaultBlockReward {
+		t.Fatalf("3 blocks minting %d TUB should be verified, got %+v", 3*DefaultBlockReward, result)
+	}
+
+	store, err := openBlockStore(dataDir, BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged, err := NewBlock(head, 3, 0, 1<<62, 0, NewAccount("0x9"), Hash{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedHash, err := forged.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Append(BlockFs{forgedHash, forged}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetHead(forgedHash); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	result, err = VerifyChain(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Failure == nil || result.Failure.Number != 3 || !strings.Contains(result.Failure.Reason, "difficulty") {
+		t.Fatalf("verification should fail at the forged block 3 because of its difficulty, got %+v", result.Failure)
+	}
+
+	if result.Blocks != 3 || result.StoredBlocks != 4 || result.Head != head {
+		t.Fatalf("totals should cover the 3 valid blocks, got %+v", result)
+	}
+}
+
+func TestVerifyChain_CorruptedRecord(t *testing.T) {
+	s, dataDir := newTestChainState(t, "bar")
+	defer os.RemoveAll(dataDir)
+
+	for i := 0; i < 2; i++ {
+		mineTestBlock(t, s)
+	}
+	head := s.LatestBlockHash()
+	s.Close()
+
+	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("00ff00ff")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := os.Remove(getBlocksIndexFilePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := VerifyChain(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Failure == nil || result.Failure.Number != 2 || !strings.Contains(result.Failure.Reason, "torn or corrupted") {
+		t.Fatalf("verification should fail at block 2 because of the torn record, got %+v", result.Failure)
+	}
+
+	if result.Blocks != 2 || result.Head != head {
+		t.Fatalf("totals should cover the 2 valid blocks, got %+v", result)
+	}
+
+	after, err := ioutil.ReadFile(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Fatal("verification must not repair block.db")
+	}
+
+	if fileExist(getBlocksIndexFilePath(dataDir)) {
+		t.Fatal("verification must not rebuild block.idx")
+	}
+}
@@ -17,6 +17,14 @@ func writeRes(w http.ResponseWriter, content interface{}) {
 	w.Write(resJson)
 }
 
+// writeBinaryRes sends content in the canonical binary encoding peers
+// exchange blocks with.
+func writeBinaryRes(w http.ResponseWriter, content []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
 func writeErrRes(w http.ResponseWriter, err error) {
 	errJson, err := json.Marshal(ErrRes{err.Error()})
 	if err != nil {
@@ -55,3 +63,22 @@ func readRes(r *http.Response, reqBody interface{}) error {
 
 	return nil
 }
+
+func readBinaryRes(r *http.Response) ([]byte, error) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body. %s", err.Error())
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		errRes := ErrRes{}
+		if err := json.Unmarshal(content, &errRes); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal error response. %s", err.Error())
+		}
+
+		return nil, fmt.Errorf(errRes.Error)
+	}
+
+	return content, nil
+}
@@ -1,12 +1,14 @@
 package node
 
 import (
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github/wizzybenson/unblockchain/database"
 	"github/wizzybenson/unblockchain/wallet"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type ErrRes struct {
@@ -15,6 +17,7 @@ type ErrRes struct {
 
 type BalancesRes struct {
 	Hash     database.Hash             `json:"block_hash"`
+	Asset    string                  `json:"asset"`
 	Balances map[common.Address]uint `json:"balances"`
 }
 
@@ -22,11 +25,13 @@ type StatusRes struct {
 	Hash       database.Hash       `json:"block_hash"`
 	Number     uint64              `json:"block_number"`
 	KnownPeers map[string]PeerNode `json:"peers_known"`
-	PendingTxs []database.Tx       `json:"pending_txs"`
+	PendingTxs []database.SignedTx `json:"pending_txs"`
 }
 
-type SyncRes struct {
-	Blocks []database.Block `json:"blocks"`
+// TxRes is a canonical tx, or a tx still waiting to be mined when Pending.
+type TxRes struct {
+	database.TxInfo
+	Pending bool `json:"pending"`
 }
 
 type AddPeerRes struct {
@@ -43,7 +48,17 @@ type TxAddReq struct {
 	From   string `json:"from"`
 	FromPwd string `json:"from_pwd"`
 	Value  uint   `json:"value"`
+	Fee    uint   `json:"fee"`
 	Reason string `json:"reason"`
+	ChainId string `json:"chain_id"`
+	Asset  string `json:"asset"`
+	LockHeight uint64 `json:"lock_height"`
+	LockTime   uint64 `json:"lock_time"`
+	Kind       database.TxKind `json:"kind"`
+}
+
+type TxDryRunReq struct {
+	Txs []database.SignedTx `json:"txs"`
 }
 
 func showStatus(w http.ResponseWriter, req *http.Request, node *Node) {
@@ -71,6 +86,11 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
+	if req.ChainId != "" && req.ChainId != node.state.ChainId() {
+		writeErrRes(w, fmt.Errorf("TX is meant for chain '%s' but this node runs chain '%s'", req.ChainId, node.state.ChainId()))
+		return
+	}
+
 	if req.FromPwd == "" {
 		writeErrRes(w, fmt.Errorf("password to decrypt the %s account is required. 'from_pwd' is empty", from.String()))
 		return
@@ -78,14 +98,23 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 
 	nonce := node.state.GetNextAccountNonce(from)
 
-	tx := database.NewTx(database.NewAccount(req.To), database.NewAccount(req.From), req.Value, nonce, req.Reason)
+	tx := database.NewTx(database.NewAccount(req.To), database.NewAccount(req.From), req.Value, req.Fee, nonce, req.Reason)
+	tx.Asset = req.Asset
+	tx.LockHeight = req.LockHeight
+	tx.LockTime = req.LockTime
+	tx.Kind = req.Kind
 
-	signedTx, err := wallet.SignWithKeystoreAccount(tx, from, req.FromPwd, wallet.GetKeystoreDirPath(node.dataDir))
+	signedTx, err := wallet.SignWithKeystoreAccount(tx, node.state.ChainId(), from, req.FromPwd, wallet.GetKeystoreDirPath(node.dataDir))
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
 
+	if err := node.state.ValidatePendingTx(signedTx); err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
 	err = node.AddPendingTX(signedTx, node.info)
 	if err != nil {
 		writeErrRes(w, err)
@@ -95,8 +124,227 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	writeRes(w, TxAddRes{Success: true})
 }
 
+// txSubmitHandler adds a TX signed outside of the node, e.g. a TX of a
+// multisig account with the cosignatures of its signers, to the pending TXs.
+func txSubmitHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	tx := database.SignedTx{}
+	err := readReq(r, &tx)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if err := node.state.ValidatePendingTx(tx); err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if err := node.state.ValidateTxSigs(tx); err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	err = node.AddPendingTX(tx, node.info)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxAddRes{Success: true})
+}
+
+// txDryRunHandler applies signed TXs on top of the latest block without
+// changing the state, reporting the TXs that would be rejected.
+func txDryRunHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := TxDryRunReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	blockTime, err := node.state.NextBlockTime(node.state.LatestBlockHash())
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, node.state.DryRunTxs(req.Txs, node.info.Account, blockTime))
+}
+
+func txProofHandler(w http.ResponseWriter, req *http.Request, node *Node) {
+	hash := database.Hash{}
+	err := hash.UnmarshalText([]byte(req.URL.Query().Get(queryKeyHash)))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	proof, err := node.state.GetTxProof(hash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, proof)
+}
+
+func accountProofHandler(w http.ResponseWriter, req *http.Request, node *Node) {
+	account := req.URL.Query().Get(queryKeyAccount)
+	if !common.IsHexAddress(account) {
+		writeErrRes(w, fmt.Errorf("'%s' is not a valid account", account))
+		return
+	}
+
+	proof, err := node.state.GetAccountProof(common.HexToAddress(account))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, proof)
+}
+
+func chainInfoHandler(w http.ResponseWriter, req *http.Request, node *Node) {
+	info, err := node.state.ChainInfo()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, info)
+}
+
+func blockHandler(w http.ResponseWriter, req *http.Request, node *Node) {
+	path := strings.TrimPrefix(req.URL.Path, endpointBlock)
+
+	var info database.BlockInfo
+	var err error
+
+	switch {
+	case path == blockPathLatest:
+		info, err = node.state.LatestBlockInfo()
+	case strings.HasPrefix(path, blockPathNumber):
+		number, parseErr := strconv.ParseUint(strings.TrimPrefix(path, blockPathNumber), 10, 64)
+		if parseErr != nil {
+			writeErrRes(w, fmt.Errorf("invalid block number. %s", parseErr.Error()))
+			return
+		}
+
+		info, err = node.state.GetBlockInfoByNumber(number)
+	default:
+		hash := database.Hash{}
+		if err := hash.UnmarshalText([]byte(path)); err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		info, err = node.state.GetBlockInfo(hash)
+	}
+
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, info)
+}
+
+func txHandler(w http.ResponseWriter, req *http.Request, node *Node) {
+	hash := database.Hash{}
+	err := hash.UnmarshalText([]byte(strings.TrimPrefix(req.URL.Path, endpointTx)))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	info, err := node.state.GetTxInfo(hash)
+	if errors.Is(err, database.ErrTxNotFound) {
+		if tx, isPending := node.pendingTXs[hash.Hex()]; isPending {
+			writeRes(w, TxRes{database.TxInfo{Hash: hash, Tx: tx}, true})
+			return
+		}
+	}
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxRes{info, false})
+}
+
+func accountTxsHandler(w http.ResponseWriter, req *http.Request, node *Node) {
+	path := strings.TrimPrefix(req.URL.Path, endpointAccount)
+	if !strings.HasSuffix(path, accountPathTxs) {
+		writeErrRes(w, fmt.Errorf("unknown account endpoint '%s'", req.URL.Path))
+		return
+	}
+
+	account := strings.TrimSuffix(path, accountPathTxs)
+	if !common.IsHexAddress(account) {
+		writeErrRes(w, fmt.Errorf("'%s' is not a valid account", account))
+		return
+	}
+
+	offset, err := queryUint(req, queryKeyOffset, 0)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	limit, err := queryUint(req, queryKeyLimit, defaultAccountTxsLimit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if limit > maxAccountTxsLimit {
+		writeErrRes(w, fmt.Errorf("limit must be at most %d", maxAccountTxsLimit))
+		return
+	}
+
+	page, err := node.state.GetAccountTxs(common.HexToAddress(account), req.URL.Query().Get(queryKeyAsset), int(offset), int(limit))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, page)
+}
+
+// queryUint parses the query param key, fallback when it is missing.
+func queryUint(req *http.Request, key string, fallback uint64) (uint64, error) {
+	raw := req.URL.Query().Get(key)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s'. %s", key, err.Error())
+	}
+
+	return value, nil
+}
+
 func listBalances(w http.ResponseWriter, req *http.Request, state *database.State) {
-	writeRes(w, BalancesRes{state.LatestBlockHash(), state.Balances})
+	asset := req.URL.Query().Get(queryKeyAsset)
+
+	balances, err := state.BalancesOf(asset)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if asset == "" {
+		asset = database.NativeAsset
+	}
+
+	writeRes(w, BalancesRes{state.LatestBlockHash(), asset, balances})
+}
+
+func listAssets(w http.ResponseWriter, req *http.Request, state *database.State) {
+	writeRes(w, state.Assets())
 }
 
 func addPeerHandler(w http.ResponseWriter, req *http.Request, node *Node) {
@@ -129,11 +377,17 @@ func syncHandler(w http.ResponseWriter, req *http.Request, node *Node) {
 		return
 	}
 
-	blocks, err := database.GetBlocksAfter(hash, node.dataDir)
+	blocks, err := node.state.GetBlocksAfter(hash, syncBlocksLimit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	blocksRaw, err := database.EncodeBlocks(blocks)
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
 
-	writeRes(w, SyncRes{Blocks: blocks})
+	writeBinaryRes(w, blocksRaw)
 }
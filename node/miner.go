@@ -13,27 +13,37 @@ import (
 type PendingBlock struct {
 	parent database.Hash
 	number uint64
+	difficulty uint64
 	time   uint64
 	miner common.Address
+	stateRoot database.Hash
 	txs    []database.SignedTx
 }
 
-func NewPendingBlock(parent database.Hash, number uint64, miner common.Address, txs []database.SignedTx) PendingBlock {
-	return PendingBlock{parent, number, uint64(time.Now().Unix()), miner, txs}
+// NewPendingBlock selects, in order, as many of txs as fit in a block under
+// limits. The state root of the selected txs is left for the caller to set.
+func NewPendingBlock(parent database.Hash, number uint64, difficulty uint64, time uint64, miner common.Address, limits database.BlockLimits, txs []database.SignedTx) (PendingBlock, error) {
+	selected, err := limits.SelectTxs(txs, time)
+	if err != nil {
+		return PendingBlock{}, err
+	}
+
+	return PendingBlock{parent, number, difficulty, time, miner, database.Hash{}, selected}, nil
 }
 
+// Mine searches the nonce of pb. It mines empty blocks too, callers only mine
+// them to reach the height of locked txs.
 func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
-	if len(pb.txs) == 0 {
-		return database.Block{}, fmt.Errorf("mining empty blocks is not allowed")
-	}
-
 	start := time.Now()
 	attempt := 0
-	var block database.Block
 	var hash database.Hash
-	var nonce uint32
 
-	for !database.IsBlockHashValid(hash) {
+	block, err := database.NewBlock(pb.parent, pb.number, 0, pb.difficulty, pb.time, pb.miner, pb.stateRoot, pb.txs)
+	if err != nil {
+		return database.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
+	}
+
+	for attempt == 0 || !database.IsBlockHashValid(hash, pb.difficulty) {
 		select {
 		case <-ctx.Done():
 			fmt.Println("Mining cancelled")
@@ -42,20 +52,12 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 		}
 
 		attempt++
-		nonce = generateNonce()
+		block.Header.Nonce = generateNonce()
 
 		if attempt%1000000 == 0 || attempt == 1 {
 			fmt.Printf("Mining %d pending TXs. Attempt: %d\n", len(pb.txs), attempt)
 		}
 
-		block = database.NewBlock(
-				pb.parent,
-				pb.number,
-				nonce,
-				pb.time,
-				pb.miner,
-				pb.txs,
-			)
 		blockHash, err := block.Hash()
 		if err != nil {
 			return database.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
@@ -66,6 +68,7 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 	fmt.Printf("\nMined new Block '%x' using PoW %s: \n", hash, fs.Unicode("\\U1F389"))
 	fmt.Printf("\tHeight: '%v'\n", block.Header.Number)
 	fmt.Printf("\tNonce: '%v'\n", block.Header.Nonce)
+	fmt.Printf("\tDifficulty: '%v'\n", block.Header.Difficulty)
 	fmt.Printf("\tCreated: '%v'\n", block.Header.Time)
 	fmt.Printf("\tMiner: '%v'\n", block.Header.Miner)
 	fmt.Printf("\tParent: '%v'\n", block.Header.Parent.Hex())
@@ -14,13 +14,16 @@ import (
 	"time"
 )
 
+const testDifficulty = 1 << 10
+const testChainId = "tub-test"
+
 func TestValidBlockHash(t *testing.T) {
 	hexHash := "000000fa04f8160395c387277f8b2f14837603383d33809a4db586086168edfa"
 	var hash = database.Hash{}
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := database.IsBlockHashValid(hash)
+	isValid := database.IsBlockHashValid(hash, database.DefaultDifficulty)
 	if !isValid {
 		t.Fatalf("hash '%s' starting with 6 zeroes is supposed to be valid", hexHash)
 	}
@@ -32,7 +35,7 @@ func TestInvalidBlockHash(t *testing.T) {
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := database.IsBlockHashValid(hash)
+	isValid := database.IsBlockHashValid(hash, database.DefaultDifficulty)
 	if isValid {
 		t.Fatalf("valid hash should start with 6 zeroes")
 	}
@@ -45,7 +48,7 @@ func TestMine(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner)
+	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner, testDifficulty)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,7 +65,7 @@ func TestMine(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !database.IsBlockHashValid(minedBlockHash) {
+	if !database.IsBlockHashValid(minedBlockHash, testDifficulty) {
 		t.Fatal()
 	}
 
@@ -77,12 +80,13 @@ func TestMineWithTimeout(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner)
+	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner, database.DefaultDifficulty)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), time.Microsecond*100)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond*100)
+	defer cancel()
 
 	_, err = Mine(ctx, pendingBlock)
 	if err == nil {
@@ -90,20 +94,23 @@ func TestMineWithTimeout(t *testing.T) {
 	}
 }
 
-func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc common.Address) (PendingBlock, error) {
-	tx := database.NewTx(database.NewAccount(testKsMawAccount), acc, 1,1, "")
+func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc common.Address, difficulty uint64) (PendingBlock, error) {
+	tx := database.NewTx(database.NewAccount(testKsMawAccount), acc, 1, 0, 1, "")
 
-	signedTx, err := wallet.SignTx(tx, privKey)
+	signedTx, err := wallet.SignTx(tx, testChainId, privKey)
 	if err != nil {
 		return PendingBlock{}, err
 	}
 
 	return NewPendingBlock(
-			database.Hash{},
-			0,
-			acc,
-			[]database.SignedTx{signedTx},
-		), nil
+		database.Hash{},
+		0,
+		difficulty,
+		uint64(time.Now().Unix()),
+		acc,
+		database.DefaultBlockLimits(),
+		[]database.SignedTx{signedTx},
+	)
 }
 
 func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, common.Address, error) {
@@ -119,4 +126,61 @@ func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, common.Address, error) {
 	account := common.BytesToAddress(pubKeyBytesHash[12:])
 
 	return privKey, pubKey, account, nil
-}
\ No newline at end of file
+}
+
+func TestOrderTXsByFee(t *testing.T) {
+	alice := database.NewAccount("0x01")
+	bob := database.NewAccount("0x02")
+
+	newTx := func(from common.Address, fee uint, nonce uint) database.SignedTx {
+		return database.NewSignedTx(database.NewTx(database.NewAccount("0x03"), from, 1, fee, nonce, ""), nil)
+	}
+
+	txs := []database.SignedTx{
+		newTx(alice, 1, 1),
+		newTx(alice, 9, 2),
+		newTx(bob, 5, 1),
+		newTx(bob, 3, 2),
+	}
+
+	ordered := orderTXsByFee(txs)
+
+	expected := []struct {
+		from  common.Address
+		nonce uint
+	}{{bob, 1}, {bob, 2}, {alice, 1}, {alice, 2}}
+
+	for i, e := range expected {
+		if ordered[i].From != e.from || ordered[i].Nonce != e.nonce {
+			t.Fatalf("TX %d should be nonce %d of '%s', got nonce %d of '%s'", i, e.nonce, e.from.Hex(), ordered[i].Nonce, ordered[i].From.Hex())
+		}
+	}
+}
+
+func TestMatureTXs(t *testing.T) {
+	alice := database.NewAccount("0x01")
+	bob := database.NewAccount("0x02")
+
+	newTx := func(from common.Address, nonce uint, lockHeight uint64) database.SignedTx {
+		tx := database.NewTx(database.NewAccount("0x03"), from, 1, 1, nonce, "")
+		tx.LockHeight = lockHeight
+
+		return database.NewSignedTx(tx, nil)
+	}
+
+	txs := []database.SignedTx{
+		newTx(alice, 1, 0),
+		newTx(alice, 2, 5),
+		newTx(alice, 3, 0),
+		newTx(bob, 1, 4),
+	}
+
+	mature := matureTXs(txs, 4, uint64(time.Now().Unix()))
+	if len(mature) != 2 || mature[0].Nonce != 1 || mature[1].From != bob {
+		t.Fatalf("locked TXs and the later TXs of their sender should be held, got %+v", mature)
+	}
+
+	if !waitsForHeight(txs, 4) || waitsForHeight(txs, 5) {
+		t.Fatal("an empty block should be mined while a TX is locked until a later block only")
+	}
+}
@@ -1,6 +1,7 @@
 package node
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"github/wizzybenson/unblockchain/database"
 	"github/wizzybenson/unblockchain/wallet"
 	"net/http"
+	"sort"
 	"time"
 )
 
@@ -22,6 +24,45 @@ const endpointStatus = "/node/status"
 const endpointSync = "/node/sync"
 const querykeyFromBlock = "fromBlock"
 
+// syncBlocksLimit bounds the blocks of a single sync response, which are at
+// most syncBlocksLimit times the max block size of the chain.
+const syncBlocksLimit = 64
+
+// reorgsBufferSize bounds the reorgs waiting for the miner to return their
+// orphaned TXs to the pending pool.
+const reorgsBufferSize = 16
+
+const endpointTxProof = "/tx/proof"
+const endpointTxDryRun = "/tx/dryrun"
+const endpointTxSubmit = "/tx/submit"
+const queryKeyHash = "hash"
+
+const endpointAccountProof = "/account/proof"
+const queryKeyAccount = "account"
+
+const endpointChainInfo = "/chain/info"
+
+// endpointBlock serves /block/latest, /block/number/{n} and /block/{hash},
+// endpointTx serves /tx/{hash}.
+const endpointBlock = "/block/"
+const blockPathLatest = "latest"
+const blockPathNumber = "number/"
+const endpointTx = "/tx/"
+
+// endpointAccount serves /account/{addr}/txs, a page of the txs of an account.
+const endpointAccount = "/account/"
+const accountPathTxs = "/txs"
+const queryKeyOffset = "offset"
+const queryKeyLimit = "limit"
+const defaultAccountTxsLimit = 20
+
+// queryKeyAsset picks the asset of /balances/list, TUB when empty, and
+// filters the txs of an account.
+const queryKeyAsset = "asset"
+
+const endpointAssets = "/assets/list"
+const maxAccountTxsLimit = 100
+
 const endpointAddPeer = "/node/peer"
 const queryKeyIp = "ip"
 const queryKeyPort = "port"
@@ -39,6 +80,7 @@ type PeerNode struct {
 
 type Node struct {
 	dataDir         string
+	dbBackend       string
 	info            PeerNode
 	state           *database.State
 	knownPeers      map[string]PeerNode
@@ -49,12 +91,13 @@ type Node struct {
 	isMining        bool
 }
 
-func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap PeerNode) *Node {
+func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap PeerNode, dbBackend string) *Node {
 	knownPeers := make(map[string]PeerNode)
 	knownPeers[bootstrap.TcpAddress()] = bootstrap
 
 	return &Node{
 		dataDir:         dataDir,
+		dbBackend:       dbBackend,
 		info:            NewPeerNode(ip, port, false, acc,true),
 		knownPeers:      knownPeers,
 		pendingTXs:      make(map[string]database.SignedTx),
@@ -76,7 +119,7 @@ func (pn PeerNode) TcpAddress() string {
 func (n *Node) Run(ctx context.Context) error {
 	fmt.Println(fmt.Sprintf("Listening on HTTP port %s:%d", n.info.IP, n.info.Port))
 
-	state, err := database.NewStateFromDisk(n.dataDir)
+	state, err := database.NewStateFromDiskWithBackend(n.dataDir, n.dbBackend)
 	if err != nil {
 		return err
 	}
@@ -91,27 +134,65 @@ func (n *Node) Run(ctx context.Context) error {
 	go n.sync(ctx)
 	go n.mine(ctx)
 
-	http.HandleFunc("/balances/list", func(w http.ResponseWriter, req *http.Request) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/balances/list", func(w http.ResponseWriter, req *http.Request) {
 		listBalances(w, req, state)
 	})
 
-	http.HandleFunc("/tx/add", func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc(endpointAssets, func(w http.ResponseWriter, req *http.Request) {
+		listAssets(w, req, state)
+	})
+
+	mux.HandleFunc("/tx/add", func(w http.ResponseWriter, req *http.Request) {
 		txAddHandler(w, req, n)
 	})
 
-	http.HandleFunc(endpointStatus, func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc(endpointTxSubmit, func(w http.ResponseWriter, req *http.Request) {
+		txSubmitHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointTxDryRun, func(w http.ResponseWriter, req *http.Request) {
+		txDryRunHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointTxProof, func(w http.ResponseWriter, req *http.Request) {
+		txProofHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointAccountProof, func(w http.ResponseWriter, req *http.Request) {
+		accountProofHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointChainInfo, func(w http.ResponseWriter, req *http.Request) {
+		chainInfoHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointBlock, func(w http.ResponseWriter, req *http.Request) {
+		blockHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointTx, func(w http.ResponseWriter, req *http.Request) {
+		txHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointAccount, func(w http.ResponseWriter, req *http.Request) {
+		accountTxsHandler(w, req, n)
+	})
+
+	mux.HandleFunc(endpointStatus, func(w http.ResponseWriter, req *http.Request) {
 		showStatus(w, req, n)
 	})
 
-	http.HandleFunc(endpointSync, func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc(endpointSync, func(w http.ResponseWriter, req *http.Request) {
 		syncHandler(w, req, n)
 	})
 
-	http.HandleFunc(endpointAddPeer, func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc(endpointAddPeer, func(w http.ResponseWriter, req *http.Request) {
 		addPeerHandler(w, req, n)
 	})
 
-	server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port)}
+	server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port), Handler: mux}
 
 	go func() {
 		<-ctx.Done()
@@ -131,6 +212,9 @@ func (n *Node) mine(ctx context.Context) error {
 
 	ticker := time.NewTicker(time.Second * miningIntervalSeconds)
 
+	reorgs := n.state.Subscribe(database.EventReorg, reorgsBufferSize, database.OverflowDrop)
+	defer reorgs.Unsubscribe()
+
 	for {
 		select {
 		case <-ticker.C:
@@ -156,6 +240,21 @@ func (n *Node) mine(ctx context.Context) error {
 				stopCurrentMining()
 			}
 
+		case event, ok := <-reorgs.Events():
+			if !ok {
+				ticker.Stop()
+				return nil
+			}
+
+			reorg := event.(database.Reorg)
+			fmt.Printf("\nChain reorganized to '%s', %d TXs returned to the pending pool\n", reorg.NewHead.Hex(), len(reorg.Orphaned))
+
+			if n.isMining {
+				stopCurrentMining()
+			}
+
+			n.restoreOrphanedTXs(reorg.Orphaned)
+
 		case <-ctx.Done():
 			ticker.Stop()
 			return nil
@@ -225,17 +324,18 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 }
 
 func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
-	localBlockNumber := n.state.LatestBlock().Header.Number
-	if localBlockNumber < status.Number {
-		newBlocksCount := status.Number - localBlockNumber
+	if status.Hash.IsEmpty() || n.state.NextBlockNumber() > status.Number {
+		return nil
+	}
 
-		fmt.Printf("Found %d new blocks from peer %s\n", newBlocksCount, peer.TcpAddress())
+	fmt.Printf("Found new blocks up to %d from peer %s\n", status.Number, peer.TcpAddress())
 
-		blocks, err := fetchBlocksFromPeer(peer, n.state.LatestBlockHash())
-		if err != nil {
-			return err
-		}
+	blocks, err := n.fetchMissingBlocks(peer)
+	if err != nil {
+		return err
+	}
 
+	for len(blocks) > 0 {
 		for _, block := range blocks {
 			_, err = n.state.AddBlock(block)
 			if err != nil {
@@ -245,11 +345,62 @@ func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
 			n.newSyncedBlocks <- block
 		}
 
+		if len(blocks) < syncBlocksLimit {
+			return nil
+		}
+
+		lastHash, err := blocks[len(blocks)-1].Hash()
+		if err != nil {
+			return err
+		}
+
+		blocks, err = fetchBlocksFromPeer(peer, lastHash)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// fetchMissingBlocks asks the peer for the blocks following our head. When the
+// peer doesn't know our head because we are on a different branch, it retries
+// from exponentially older canonical blocks down to the genesis.
+func (n *Node) fetchMissingBlocks(peer PeerNode) ([]database.Block, error) {
+	if n.state.NextBlockNumber() == 0 {
+		return fetchBlocksFromPeer(peer, database.Hash{})
+	}
+
+	step := uint64(1)
+	for number := n.state.LatestBlock().Header.Number; ; {
+		b, err := n.state.GetBlockByNumber(number)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		blocks, err := fetchBlocksFromPeer(peer, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(blocks) > 0 || number == 0 {
+			return blocks, nil
+		}
+
+		if number < step {
+			number = 0
+		} else {
+			number -= step
+		}
+		step *= 2
+	}
+}
+
 func (n *Node) syncKnownPeers(peer PeerNode, status StatusRes) error {
 	for _, statusPeer := range status.KnownPeers {
 		if !n.IsKnownPeer(statusPeer) {
@@ -294,8 +445,92 @@ func (n *Node) getPendingTXsAsArray() []database.SignedTx {
 	return txs
 }
 
+// orderTXsByFee orders txs highest fee first, while keeping the txs of each
+// sender in nonce order so a block built from any prefix stays valid.
+func orderTXsByFee(txs []database.SignedTx) []database.SignedTx {
+	bySender := make(map[common.Address][]database.SignedTx)
+	for _, tx := range txs {
+		bySender[tx.From] = append(bySender[tx.From], tx)
+	}
+
+	for _, senderTxs := range bySender {
+		sort.Slice(senderTxs, func(i, j int) bool {
+			return senderTxs[i].Nonce < senderTxs[j].Nonce
+		})
+	}
+
+	ordered := make([]database.SignedTx, 0, len(txs))
+	for len(bySender) > 0 {
+		var next common.Address
+		found := false
+		for sender, senderTxs := range bySender {
+			if !found || isPrioritized(senderTxs[0], bySender[next][0]) {
+				next, found = sender, true
+			}
+		}
+
+		ordered = append(ordered, bySender[next][0])
+		if len(bySender[next]) == 1 {
+			delete(bySender, next)
+		} else {
+			bySender[next] = bySender[next][1:]
+		}
+	}
+
+	return ordered
+}
+
+// matureTXs keeps the txs that can be included in block number, timed
+// blockTime. Time locked txs stay pending until they mature, along with the
+// txs their sender signed after them.
+func matureTXs(txs []database.SignedTx, number uint64, blockTime uint64) []database.SignedTx {
+	held := make(map[common.Address]bool)
+	mature := make([]database.SignedTx, 0, len(txs))
+	for _, tx := range txs {
+		if held[tx.From] || !tx.IsMatureAt(number, blockTime) {
+			held[tx.From] = true
+			continue
+		}
+
+		mature = append(mature, tx)
+	}
+
+	return mature
+}
+
+// waitsForHeight tells whether one of txs is locked until a block after
+// number.
+func waitsForHeight(txs []database.SignedTx, number uint64) bool {
+	for _, tx := range txs {
+		if tx.LockHeight > number {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrioritized tells whether tx should be mined before other, preferring
+// higher fees, then older txs.
+func isPrioritized(tx database.SignedTx, other database.SignedTx) bool {
+	if tx.Fee != other.Fee {
+		return tx.Fee > other.Fee
+	}
+
+	if tx.Time != other.Time {
+		return tx.Time < other.Time
+	}
+
+	return bytes.Compare(tx.From.Bytes(), other.From.Bytes()) < 0
+}
+
 func (n *Node) syncPendingTXs(peer PeerNode, txs []database.SignedTx) error {
 	for _, tx := range txs {
+		if err := n.state.ValidateTxChain(tx); err != nil {
+			fmt.Printf("Rejected pending TX from Peer %s. %s\n", peer.TcpAddress(), err)
+			continue
+		}
+
 		err := n.AddPendingTX(tx, peer)
 		if err != nil {
 			return err
@@ -305,12 +540,47 @@ func (n *Node) syncPendingTXs(peer PeerNode, txs []database.SignedTx) error {
 }
 
 func (n *Node) minePendingTXs(ctx context.Context) interface{} {
-	blockToMine := NewPendingBlock(
+	difficulty, err := n.state.NextDifficulty(n.state.LatestBlockHash())
+	if err != nil {
+		return err
+	}
+
+	blockTime, err := n.state.NextBlockTime(n.state.LatestBlockHash())
+	if err != nil {
+		return err
+	}
+
+	number := n.state.NextBlockNumber()
+
+	n.evictStalePendingTXs()
+
+	blockToMine, err := NewPendingBlock(
 		n.state.LatestBlockHash(),
-		n.state.LatestBlock().Header.Number+1,
+		number,
+		difficulty,
+		blockTime,
 		n.info.Account,
-		n.getPendingTXsAsArray(),
+		n.state.BlockLimits(),
+		matureTXs(orderTXsByFee(n.getPendingTXsAsArray()), number, blockTime),
 	)
+	if err != nil {
+		return err
+	}
+
+	dryRun := n.state.DryRunTxs(blockToMine.txs, n.info.Account, blockTime)
+	for _, rejected := range dryRun.Rejected {
+		txHash, _ := rejected.Tx.Hash()
+		fmt.Printf("Leaving pending TX '%s' out of the block. %s\n", txHash.Hex(), rejected.Reason)
+	}
+
+	blockToMine.txs = dryRun.Applied
+	blockToMine.stateRoot = dryRun.StateRoot
+
+	// An empty block is only worth mining to reach the height of locked txs,
+	// otherwise they would wait for other txs forever on an idle chain.
+	if len(blockToMine.txs) == 0 && !waitsForHeight(n.getPendingTXsAsArray(), number) {
+		return nil
+	}
 
 	minedBlock, err := Mine(ctx, blockToMine)
 	if err != nil {
@@ -327,6 +597,17 @@ func (n *Node) minePendingTXs(ctx context.Context) interface{} {
 	return nil
 }
 
+// evictStalePendingTXs drops the pending txs that can never be mined, so they
+// don't stay in the pool forever.
+func (n *Node) evictStalePendingTXs() {
+	for txHash, tx := range n.pendingTXs {
+		if err := n.state.ValidatePendingTx(tx); err != nil {
+			fmt.Printf("Evicting pending TX '%s'. %s\n", txHash, err)
+			delete(n.pendingTXs, txHash)
+		}
+	}
+}
+
 func (n *Node) removeMinedPendingTXs(block database.Block) {
 	if len(block.Txs) > 0 && len(n.pendingTXs) > 0 {
 		fmt.Println("Updating in-memory pending Txs pool")
@@ -342,3 +623,14 @@ func (n *Node) removeMinedPendingTXs(block database.Block) {
 		}
 	}
 }
+
+func (n *Node) restoreOrphanedTXs(txs []database.SignedTx) {
+	for _, tx := range txs {
+		txHash, _ := tx.Hash()
+
+		fmt.Printf("\t -restoring orphaned TX: %s\n", txHash.Hex())
+
+		delete(n.archivedTXs, txHash.Hex())
+		n.pendingTXs[txHash.Hex()] = tx
+	}
+}
@@ -42,9 +42,11 @@ func TestNode_Run(t *testing.T) {
 		8086,
 		database.NewAccount(DefaultMiner),
 		PeerNode{},
+		database.DefaultBackend,
 	)
 
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
 	err = n.Run(ctx)
 	if err.Error() != "http: Server closed" {
 		t.Fatal("node server was supposed to close after 5s")
@@ -52,7 +54,7 @@ func TestNode_Run(t *testing.T) {
 }
 
 func TestNode_Mining(t *testing.T) {
-	datadir, thanos, maw, err := setUpTestNodeDir()
+	datadir, thanos, maw, err := setUpTestNodeDir(testDifficulty)
 	if err != nil {
 		t.Error(err)
 	}
@@ -60,13 +62,14 @@ func TestNode_Mining(t *testing.T) {
 
 	nInfo := NewPeerNode("127.0.0.1", 8087, false, database.NewAccount(""), true)
 
-	n := New(datadir, nInfo.IP, nInfo.Port, thanos, nInfo)
+	n := New(datadir, nInfo.IP, nInfo.Port, thanos, nInfo, database.DefaultBackend)
 	ctx, closeNode := context.WithTimeout(context.Background(), time.Minute*30)
+	defer closeNode()
 
 	go func() {
 		time.Sleep(time.Second * miningIntervalSeconds / 3)
-		tx := database.NewTx(maw, thanos, 1, 1,"")
-		signedTx, err := wallet.SignWithKeystoreAccount(tx, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+		tx := database.NewTx(maw, thanos, 1, 0, 1, "")
+		signedTx, err := wallet.SignWithKeystoreAccount(tx, testChainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
 		if err != nil {
 			t.Error(err)
 			return
@@ -78,8 +81,8 @@ func TestNode_Mining(t *testing.T) {
 	go func() {
 		time.Sleep(time.Second*miningIntervalSeconds + 2)
 
-		tx := database.NewTx(maw, thanos, 2, 1,"")
-		signedTx, err := wallet.SignWithKeystoreAccount(tx, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+		tx := database.NewTx(maw, thanos, 1, 0, 2, "")
+		signedTx, err := wallet.SignWithKeystoreAccount(tx, testChainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
 		if err != nil {
 			t.Error(err)
 			return
@@ -94,7 +97,7 @@ func TestNode_Mining(t *testing.T) {
 		for {
 			select {
 			case <-ticker.C:
-				if n.state.LatestBlock().Header.Number == 2 {
+				if n.state.LatestBlock().Header.Number == 1 {
 					closeNode()
 					return
 				}
@@ -110,21 +113,22 @@ func TestNode_Mining(t *testing.T) {
 }
 
 func TestNode_ForgedTx(t *testing.T) {
-	datadir, thanos, maw, err := setUpTestNodeDir()
+	datadir, thanos, maw, err := setUpTestNodeDir(testDifficulty)
 	if err != nil {
 		t.Error(err)
 	}
 	defer fs.RemoveDir(datadir)
 
-	n := New(datadir, "127.0.0.1", 8087, thanos, PeerNode{})
-	ctx, _ := context.WithTimeout(context.Background(), time.Minute * 15)
+	n := New(datadir, "127.0.0.1", 8087, thanos, PeerNode{}, database.DefaultBackend)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute * 15)
+	defer cancel()
 	thanosPeerNode := NewPeerNode("127.0.0.1", 8087, false, thanos,true)
 
 	txValue := uint(5)
 	txNonce := uint(1)
-	tx := database.NewTx(maw, thanos, txValue, txNonce, "")
+	tx := database.NewTx(maw, thanos, txValue, 0, txNonce, "")
 
-	signedTx, err := wallet.SignWithKeystoreAccount(tx, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+	signedTx, err := wallet.SignWithKeystoreAccount(tx, testChainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
 	if err != nil {
 		t.Error(err)
 		return
@@ -138,10 +142,27 @@ func TestNode_ForgedTx(t *testing.T) {
 
 	go func() {
 		time.Sleep(time.Second * (miningIntervalSeconds + 1))
-		forgedTx := database.NewTx(maw, thanos, txValue, txNonce, "")
+		forgedTx := database.NewTx(maw, thanos, txValue, 0, txNonce, "")
 		forgedSignedTx := database.NewSignedTx(forgedTx, signedTx.Sig)
 
 		_ = n.AddPendingTX(forgedSignedTx, thanosPeerNode)
+
+		// The forged TX is left out of the blocks mined at the next interval.
+		time.Sleep(time.Second * miningIntervalSeconds)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !n.isMining {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
 	_ = n.Run(ctx)
@@ -152,22 +173,23 @@ func TestNode_ForgedTx(t *testing.T) {
 }
 
 func TestNode_ReplayedTx(t *testing.T) {
-	datadir, thanos, maw, err := setUpTestNodeDir()
+	datadir, thanos, maw, err := setUpTestNodeDir(testDifficulty)
 	if err != nil {
 		t.Error(err)
 	}
 	defer fs.RemoveDir(datadir)
 
-	n := New(datadir, "127.0.0.1", 8087, thanos, PeerNode{})
+	n := New(datadir, "127.0.0.1", 8087, thanos, PeerNode{}, database.DefaultBackend)
 	ctx, closeNode := context.WithCancel(context.Background())
+	defer closeNode()
 	thanosPeerNode := NewPeerNode("127.0.0.1", 8087, false, thanos,true)
 	mawPeerNode := NewPeerNode("127.0.0.1", 8088, false, maw,true)
 
 	txValue := uint(5)
 	txNonce := uint(1)
-	tx := database.NewTx(maw, thanos, txValue, txNonce, "")
+	tx := database.NewTx(maw, thanos, txValue, 0, txNonce, "")
 
-	signedTx, err := wallet.SignWithKeystoreAccount(tx, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+	signedTx, err := wallet.SignWithKeystoreAccount(tx, testChainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
 	if err != nil {
 		t.Error(err)
 		return
@@ -210,141 +232,160 @@ func TestNode_ReplayedTx(t *testing.T) {
 	}
 }
 
-func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
-	datadir, thanos, maw, err := setUpTestNodeDir()
+func TestNode_MinesLockedTXsOnIdleChain(t *testing.T) {
+	datadir, thanos, maw, err := setUpTestNodeDir(testDifficulty)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	defer fs.RemoveDir(datadir)
 
+	n := New(datadir, "127.0.0.1", 8087, thanos, PeerNode{}, database.DefaultBackend)
+	n.state, err = database.NewStateFromDiskWithBackend(datadir, database.DefaultBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+
+	signTx := func(nonce uint, lockHeight uint64, chainId string) database.SignedTx {
+		tx := database.NewTx(maw, thanos, 5, 1, nonce, "")
+		tx.LockHeight = lockHeight
+
+		signedTx, err := wallet.SignWithKeystoreAccount(tx, chainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signedTx
+	}
+
+	peer := NewPeerNode("127.0.0.1", 8088, false, maw, true)
+	// Mining empty blocks until a far-future lock would never end.
+	farLocked := signTx(2, database.MaxLockHeightDistance+1, testChainId)
+	for _, tx := range []database.SignedTx{signTx(1, 2, testChainId), signTx(0, 0, testChainId), signTx(1, 0, "other"), farLocked} {
+		if err := n.AddPendingTX(tx, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := n.minePendingTXs(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n.state.LatestBlock().Header.Number != 2 || n.state.Balances[maw] != 5 {
+		t.Fatalf("empty blocks should be mined until the locked TX is mined in block 2, got block %d and %d TUB", n.state.LatestBlock().Header.Number, n.state.Balances[maw])
+	}
+
+	if len(n.pendingTXs) != 0 {
+		t.Fatalf("TXs with a used nonce, for another chain or locked too far ahead should be evicted, %d are pending", len(n.pendingTXs))
+	}
+}
+
+// TestNode_SyncedBlockTXsAreNotMinedAgain checks a pending TX included in a
+// block synced from a peer is dropped, while the others are still mined.
+// Blocks are mined at testDifficulty in a few milliseconds, so the node can't
+// be caught mining when the synced block arrives.
+func TestNode_SyncedBlockTXsAreNotMinedAgain(t *testing.T) {
+	datadir, thanos, maw, err := setUpTestNodeDir(testDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer fs.RemoveDir(datadir)
 
 	nInfo := NewPeerNode("127.0.0.1", 8087, false, database.NewAccount(""), true)
 
-	n := New(datadir, nInfo.IP, nInfo.Port, thanos, nInfo)
-	ctx, closeNode := context.WithTimeout(context.Background(), time.Minute*30)
+	n := New(datadir, nInfo.IP, nInfo.Port, thanos, nInfo, database.DefaultBackend)
+	ctx, closeNode := context.WithTimeout(context.Background(), time.Minute*5)
+	defer closeNode()
 
-	tx := database.NewTx(maw, thanos, 1, 1,"")
-	tx2 := database.NewTx(maw, thanos, 2, 1,"")
+	tx := database.NewTx(maw, thanos, 1, 0, 1, "")
+	tx2 := database.NewTx(maw, thanos, 1, 0, 2, "")
 
-	signedTx, err := wallet.SignWithKeystoreAccount(tx, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+	signedTx, err := wallet.SignWithKeystoreAccount(tx, testChainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
 
-	signedTx2, err := wallet.SignWithKeystoreAccount(tx, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
+	signedTx2, err := wallet.SignWithKeystoreAccount(tx2, testChainId, thanos, testKsAccountsPwd, wallet.GetKeystoreDirPath(datadir))
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	tx2Hash, _ := signedTx2.Hash()
 
-	validPreMinedPb := NewPendingBlock(
-		database.Hash{},
-		1,
-		thanos,
-		[]database.SignedTx{signedTx},
-	)
-	validSyncedBlock, err := Mine(ctx, validPreMinedPb)
+	state, err := database.NewStateFromDisk(datadir)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	go func() {
-		time.Sleep(time.Second * (miningIntervalSeconds - 2))
+	blockTime := uint64(time.Now().Unix())
+	stateRoot, err := state.PendingStateRoot([]database.SignedTx{signedTx}, maw, blockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		err := n.AddPendingTX(signedTx, nInfo)
-		if err != nil {
-			t.Fatal(err)
-		}
+	difficulty, err := state.NextDifficulty(database.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Close()
 
-		err = n.AddPendingTX(signedTx2, nInfo)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}()
+	syncedPb, err := NewPendingBlock(database.Hash{}, 0, difficulty, blockTime, maw, database.DefaultBlockLimits(), []database.SignedTx{signedTx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncedPb.stateRoot = stateRoot
+	syncedBlock, err := Mine(ctx, syncedPb)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	go func() {
-		time.Sleep(time.Second * (miningIntervalSeconds + 2))
-		if !n.isMining {
-			t.Fatal("should be mining")
-		}
-
-		_, err := n.state.AddBlock(validSyncedBlock)
-		if err != nil {
-			t.Fatal(err)
-		}
-		n.newSyncedBlocks <- validSyncedBlock
-
 		time.Sleep(time.Second * 2)
-		if n.isMining {
-			t.Fatal("new received block should have cancelled mining")
-		}
-
-		_, onlyTX2IsPending := n.pendingTXs[tx2Hash.Hex()]
 
-		if len(n.pendingTXs) != 1 && !onlyTX2IsPending {
-			t.Fatal("new received block should have cancelled mining of already mined transaction")
+		for _, pendingTx := range []database.SignedTx{signedTx, signedTx2} {
+			if err := n.AddPendingTX(pendingTx, nInfo); err != nil {
+				t.Error(err)
+				return
+			}
 		}
 
-		time.Sleep(time.Second * (miningIntervalSeconds + 2))
-		if !n.isMining {
-			t.Fatal("should be mining again the 1 tx not included in synced block")
+		if _, err := n.state.AddBlock(syncedBlock); err != nil {
+			t.Error(err)
+			return
 		}
+		n.newSyncedBlocks <- syncedBlock
 	}()
 
 	go func() {
-		ticker := time.NewTicker(time.Second * 10)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				if n.state.LatestBlock().Header.Number == 1 {
+				if n.state != nil && n.state.LatestBlock().Header.Number == 1 && !n.isMining {
 					closeNode()
 					return
 				}
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
-	go func() {
-		time.Sleep(time.Second * 2)
-
-		startingThanosBalance := n.state.Balances[thanos]
-		startingMawBalance := n.state.Balances[maw]
-
-		<-ctx.Done()
-
-		endThanosBalance := n.state.Balances[thanos]
-		endMawBalances := n.state.Balances[maw]
-
-		expectedEndThanosBalance := startingThanosBalance - tx.Value - tx2.Value + database.BlockReward
-		expectedEndMawBalance := startingMawBalance + tx.Value + tx2.Value + database.BlockReward
-
-		if endThanosBalance != expectedEndThanosBalance {
-			t.Fatalf("Thanos expected end balance is %d not %d", expectedEndThanosBalance, endThanosBalance)
-		}
-
-		if endMawBalances != expectedEndMawBalance {
-			t.Fatalf("BabaYaga expected end balance is %d not %d", expectedEndMawBalance, endMawBalances)
-		}
-
-		t.Logf("Starting Thanos balance: %d", startingThanosBalance)
-		t.Logf("Starting Maw balance: %d", startingMawBalance)
-		t.Logf("Ending Thanos balance: %d", endThanosBalance)
-		t.Logf("Ending Maw balance: %d", endMawBalances)
-	}()
-
 	_ = n.Run(ctx)
 
-	if n.state.LatestBlock().Header.Number != 1 {
-		t.Fatal("was suppose to mine 2 pending TX into 2 valid blocks under 30m")
+	if n.state.LatestBlock().Header.Number != 1 || len(n.state.LatestBlock().Txs) != 1 {
+		t.Fatal("only the pending TX missing from the synced block should be mined in the next block")
+	}
+
+	if n.state.Balances[maw] != tx.Value+tx2.Value+database.DefaultBlockReward {
+		t.Fatalf("maw should have received both TXs and the synced block reward, got %d TUB", n.state.Balances[maw])
 	}
 
 	if len(n.pendingTXs) != 0 {
 		t.Fatal("no pending TXs should be left to mine")
 	}
-
 }
 
 func copyKeystoreFilesIntoTestDataDirPath(datadir string) error {
@@ -397,13 +438,13 @@ func copyKeystoreFilesIntoTestDataDirPath(datadir string) error {
 
 }
 
-func setUpTestNodeDir() (datadir string, thanos, maw common.Address, err error) {
+func setUpTestNodeDir(difficulty uint64) (datadir string, thanos, maw common.Address, err error) {
 	thanos = database.NewAccount(testKsThanosAccount)
 	maw = database.NewAccount(testKsMawAccount)
 
 	genesisBalances := make(map[common.Address]uint)
 	genesisBalances[thanos] = 1000000
-	genesis := database.Genesis{Balances: genesisBalances}
+	genesis := database.Genesis{ChainId: testChainId, Balances: genesisBalances, Difficulty: difficulty}
 	genesisJson, err := json.Marshal(genesis)
 	if err != nil {
 		return "", common.Address{}, common.Address{}, err
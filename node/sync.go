@@ -98,11 +98,10 @@ func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) ([]database.Blo
 		return nil, err
 	}
 
-	syncRes := SyncRes{}
-	err = readRes(res, &syncRes)
+	blocksRaw, err := readBinaryRes(res)
 	if err != nil {
 		return nil, err
 	}
 
-	return syncRes.Blocks, nil
+	return database.DecodeBlocks(blocksRaw)
 }
@@ -57,7 +57,10 @@ func Verify(msg, sig []byte) (*ecdsa.PublicKey, error) {
 	return recoveredPubKey, nil
 }
 
-func SignTx(tx database.Tx, privKey *ecdsa.PrivateKey) (database.SignedTx, error) {
+// SignTx binds tx to the chain chainId and signs it.
+func SignTx(tx database.Tx, chainId string, privKey *ecdsa.PrivateKey) (database.SignedTx, error) {
+	tx.ChainId = chainId
+
 	rawTx, err := tx.Encode()
 	if err != nil {
 		return database.SignedTx{}, err
@@ -71,27 +74,98 @@ func SignTx(tx database.Tx, privKey *ecdsa.PrivateKey) (database.SignedTx, error
 	return database.NewSignedTx(tx, sig), nil
 }
 
-func SignWithKeystoreAccount(tx database.Tx, acc common.Address, pwd, keystoreDir string) (database.SignedTx, error) {
-	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
-	ksAccount, err := ks.Find(accounts.Account{Address: acc})
+func SignWithKeystoreAccount(tx database.Tx, chainId string, acc common.Address, pwd, keystoreDir string) (database.SignedTx, error) {
+	key, err := decryptKeystoreAccount(acc, pwd, keystoreDir)
 	if err != nil {
 		return database.SignedTx{}, err
 	}
 
-	ksAccountJson, err := ioutil.ReadFile(ksAccount.URL.Path)
+	signedTx, err := SignTx(tx, chainId, key.PrivateKey)
 	if err != nil {
 		return database.SignedTx{}, err
 	}
 
-	key, err := keystore.DecryptKey(ksAccountJson, pwd)
+	return signedTx, nil
+}
+
+// CosignTx adds the cosignature of privKey to tx, a tx of a multisig account
+// already bound to its chain.
+func CosignTx(tx database.SignedTx, privKey *ecdsa.PrivateKey) (database.SignedTx, error) {
+	rawTx, err := tx.Tx.Encode()
 	if err != nil {
-		return database.SignedTx{}, nil
+		return database.SignedTx{}, err
 	}
 
-	signedTx, err := SignTx(tx, key.PrivateKey)
+	sig, err := Sign(rawTx, privKey)
 	if err != nil {
 		return database.SignedTx{}, err
 	}
 
-	return signedTx, nil
-}
\ No newline at end of file
+	tx.Sigs = append(append([][]byte{}, tx.Sigs...), sig)
+
+	return CombineCosignedTxs([]database.SignedTx{tx})
+}
+
+func CosignWithKeystoreAccount(tx database.SignedTx, acc common.Address, pwd, keystoreDir string) (database.SignedTx, error) {
+	key, err := decryptKeystoreAccount(acc, pwd, keystoreDir)
+	if err != nil {
+		return database.SignedTx{}, err
+	}
+
+	return CosignTx(tx, key.PrivateKey)
+}
+
+// CombineCosignedTxs merges the cosignatures of copies of the same tx, each
+// signed by some of the signers, keeping one cosignature per signer.
+func CombineCosignedTxs(txs []database.SignedTx) (database.SignedTx, error) {
+	if len(txs) == 0 {
+		return database.SignedTx{}, fmt.Errorf("no TX to combine")
+	}
+
+	combined := database.NewSignedTx(txs[0].Tx, nil)
+	hash, err := combined.Hash()
+	if err != nil {
+		return database.SignedTx{}, err
+	}
+
+	cosigned := make(map[common.Address]bool)
+	for _, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return database.SignedTx{}, err
+		}
+
+		if txHash != hash {
+			return database.SignedTx{}, fmt.Errorf("TX '%s' differs from TX '%s', only cosignatures of the same TX can be combined", txHash.Hex(), hash.Hex())
+		}
+
+		cosigners, err := tx.Cosigners()
+		if err != nil {
+			return database.SignedTx{}, err
+		}
+
+		for i, cosigner := range cosigners {
+			if !cosigned[cosigner] {
+				cosigned[cosigner] = true
+				combined.Sigs = append(combined.Sigs, tx.Sigs[i])
+			}
+		}
+	}
+
+	return combined, nil
+}
+
+func decryptKeystoreAccount(acc common.Address, pwd, keystoreDir string) (*keystore.Key, error) {
+	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
+	ksAccount, err := ks.Find(accounts.Account{Address: acc})
+	if err != nil {
+		return nil, err
+	}
+
+	ksAccountJson, err := ioutil.ReadFile(ksAccount.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return keystore.DecryptKey(ksAccountJson, pwd)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github/wizzybenson/unblockchain/database"
 	"testing"
 )
 
@@ -41,3 +42,85 @@ func TestSign(t *testing.T) {
 		t.Fatalf("message was signed by account %s but signature recovery produced an account %s", account.Hex(), recoveredAccount.Hex())
 	}
 }
+
+func TestSignTx(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKeyBytes := elliptic.Marshal(crypto.S256(), privKey.PublicKey.X, privKey.PublicKey.Y)
+	account := common.BytesToAddress(crypto.Keccak256(pubKeyBytes[1:])[12:])
+
+	tx := database.NewTx(database.NewAccount(MawAccount), account, 1, 0, 1, "")
+	signedTx, err := SignTx(tx, "bar-test", privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signedTx.ChainId != "bar-test" {
+		t.Fatalf("signed TX should be bound to chain 'bar-test', got '%s'", signedTx.ChainId)
+	}
+
+	ok, err := signedTx.IsAuthentic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("signed TX should be authentic")
+	}
+
+	signedTx.ChainId = "bar-prod"
+	ok, err = signedTx.IsAuthentic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("TX moved to another chain should not be authentic")
+	}
+}
+
+func TestCombineCosignedTxs(t *testing.T) {
+	signers := make([]*ecdsa.PrivateKey, 2)
+	for i := range signers {
+		privKey, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[i] = privKey
+	}
+
+	tx := database.NewTx(database.NewAccount(MawAccount), database.NewAccount(ThanosAccount), 1, 0, 1, "")
+	tx.ChainId = "bar-test"
+	unsigned := database.NewSignedTx(tx, nil)
+
+	first, err := CosignTx(unsigned, signers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := CosignTx(unsigned, signers[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	combined, err := CombineCosignedTxs([]database.SignedTx{first, second, first})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cosigners, err := combined.Cosigners()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cosigners) != 2 || cosigners[0] != crypto.PubkeyToAddress(signers[0].PublicKey) || cosigners[1] != crypto.PubkeyToAddress(signers[1].PublicKey) {
+		t.Fatalf("combined TX should be cosigned once by each signer, got %v", cosigners)
+	}
+
+	other := unsigned
+	other.Value = 2
+	if _, err := CombineCosignedTxs([]database.SignedTx{first, other}); err == nil {
+		t.Fatal("cosignatures of different TXs should not be combined")
+	}
+}